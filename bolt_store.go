@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const BoltStoreSweepInterval = time.Minute
+
+var (
+	boltBucket = []byte("codesnap")
+	// boltSetBucket holds the sets, with their members separated by new lines
+	boltSetBucket = []byte("codesnap-sets")
+)
+
+// BoltStore is a file backed Store for installs that don't want to run
+// redis. Every value is prefixed with its expiry time as unix nanoseconds
+// (0 means no expiry); expired keys are never returned and Sweep deletes them.
+type BoltStore struct {
+	db *bolt.DB
+}
+
+func NewBoltStore(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{boltBucket, boltSetBucket} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BoltStore{db: db}, nil
+}
+
+func (b *BoltStore) Close() error {
+	return b.db.Close()
+}
+
+func (b *BoltStore) Set(_ context.Context, key string, value any, ttl time.Duration) error {
+	v, err := valueToBytes(value)
+	if err != nil {
+		return err
+	}
+	var expiresAt time.Time
+	if ttl > 0 {
+		expiresAt = time.Now().Add(ttl)
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).Put([]byte(key), encodeBoltValue(v, expiresAt))
+	})
+}
+
+func (b *BoltStore) Get(_ context.Context, key string) ([]byte, error) {
+	var res []byte
+	err := b.db.View(func(tx *bolt.Tx) error {
+		v, ok := getBoltValue(tx, key)
+		if !ok {
+			return ErrKeyNotFound
+		}
+		res = append([]byte(nil), v...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (b *BoltStore) GetDel(_ context.Context, key string) ([]byte, error) {
+	var res []byte
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		v, ok := getBoltValue(tx, key)
+		if !ok {
+			return ErrKeyNotFound
+		}
+		res = append([]byte(nil), v...)
+		return tx.Bucket(boltBucket).Delete([]byte(key))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (b *BoltStore) Del(_ context.Context, key string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(boltSetBucket).Delete([]byte(key)); err != nil {
+			return err
+		}
+		return tx.Bucket(boltBucket).Delete([]byte(key))
+	})
+}
+
+// Incr behaves like the redis INCR command: missing keys start at 0 and the
+// ttl of an existing key is kept.
+func (b *BoltStore) Incr(_ context.Context, key string) (int64, error) {
+	return b.incrBy(key, 1)
+}
+
+// Decr behaves like the redis DECR command, see Incr.
+func (b *BoltStore) Decr(_ context.Context, key string) (int64, error) {
+	return b.incrBy(key, -1)
+}
+
+func (b *BoltStore) incrBy(key string, delta int64) (int64, error) {
+	var count int64
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		var expiresAt time.Time
+		if raw := tx.Bucket(boltBucket).Get([]byte(key)); raw != nil && !boltValueExpired(raw, time.Now()) {
+			var err error
+			count, err = strconv.ParseInt(string(raw[8:]), 10, 64)
+			if err != nil {
+				return ErrNotInteger
+			}
+			expiresAt = boltValueExpiresAt(raw)
+		}
+		count += delta
+		return tx.Bucket(boltBucket).Put([]byte(key), encodeBoltValue(strconv.AppendInt(nil, count, 10), expiresAt))
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+func (b *BoltStore) Expire(_ context.Context, key string, ttl time.Duration) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{boltBucket, boltSetBucket} {
+			raw := tx.Bucket(bucket).Get([]byte(key))
+			if raw == nil || boltValueExpired(raw, time.Now()) {
+				continue
+			}
+			if ttl <= 0 {
+				return tx.Bucket(bucket).Delete([]byte(key))
+			}
+			return tx.Bucket(bucket).Put([]byte(key), encodeBoltValue(raw[8:], time.Now().Add(ttl)))
+		}
+		return nil
+	})
+}
+
+func (b *BoltStore) SAdd(_ context.Context, key string, members ...string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		set, expiresAt := getBoltSet(tx, key)
+		for _, member := range members {
+			set[member] = struct{}{}
+		}
+		return putBoltSet(tx, key, set, expiresAt)
+	})
+}
+
+func (b *BoltStore) SRem(_ context.Context, key string, members ...string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		set, expiresAt := getBoltSet(tx, key)
+		for _, member := range members {
+			delete(set, member)
+		}
+		return putBoltSet(tx, key, set, expiresAt)
+	})
+}
+
+func (b *BoltStore) SMembers(_ context.Context, key string) ([]string, error) {
+	var members []string
+	err := b.db.View(func(tx *bolt.Tx) error {
+		set, _ := getBoltSet(tx, key)
+		for member := range set {
+			members = append(members, member)
+		}
+		return nil
+	})
+	return members, err
+}
+
+// Scan calls fn for a snapshot of the keys, so fn may use the store.
+func (b *BoltStore) Scan(_ context.Context, fn func(key string) error) error {
+	var keys []string
+	err := b.db.View(func(tx *bolt.Tx) error {
+		now := time.Now()
+		return tx.Bucket(boltBucket).ForEach(func(k, v []byte) error {
+			if !boltValueExpired(v, now) {
+				keys = append(keys, string(k))
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		if err := fn(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *BoltStore) CompareAndSwap(_ context.Context, key string, oldValue, newValue []byte) (bool, error) {
+	swapped := false
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		raw := tx.Bucket(boltBucket).Get([]byte(key))
+		if raw == nil || boltValueExpired(raw, time.Now()) || !bytes.Equal(raw[8:], oldValue) {
+			return nil
+		}
+		swapped = true
+		return tx.Bucket(boltBucket).Put([]byte(key), encodeBoltValue(newValue, boltValueExpiresAt(raw)))
+	})
+	return swapped, err
+}
+
+// Sweep deletes all expired keys and returns how many were deleted.
+func (b *BoltStore) Sweep() (uint, error) {
+	sweptCount := uint(0)
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		now := time.Now()
+		for _, name := range [][]byte{boltBucket, boltSetBucket} {
+			bucket := tx.Bucket(name)
+			var expired [][]byte
+			err := bucket.ForEach(func(k, v []byte) error {
+				if boltValueExpired(v, now) {
+					expired = append(expired, append([]byte(nil), k...))
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+
+			for _, k := range expired {
+				if err := bucket.Delete(k); err != nil {
+					return err
+				}
+				sweptCount++
+			}
+		}
+		return nil
+	})
+	return sweptCount, err
+}
+
+func getBoltValue(tx *bolt.Tx, key string) ([]byte, bool) {
+	raw := tx.Bucket(boltBucket).Get([]byte(key))
+	if raw == nil || boltValueExpired(raw, time.Now()) {
+		return nil, false
+	}
+	return raw[8:], true
+}
+
+// getBoltSet returns the members of a set, which is empty if there is none.
+func getBoltSet(tx *bolt.Tx, key string) (map[string]struct{}, time.Time) {
+	set := make(map[string]struct{})
+	raw := tx.Bucket(boltSetBucket).Get([]byte(key))
+	if raw == nil || boltValueExpired(raw, time.Now()) {
+		return set, time.Time{}
+	}
+	for _, member := range strings.Split(string(raw[8:]), "\n") {
+		if member != "" {
+			set[member] = struct{}{}
+		}
+	}
+	return set, boltValueExpiresAt(raw)
+}
+
+// putBoltSet writes a set, deleting it once it is empty like redis does.
+func putBoltSet(tx *bolt.Tx, key string, set map[string]struct{}, expiresAt time.Time) error {
+	if len(set) == 0 {
+		return tx.Bucket(boltSetBucket).Delete([]byte(key))
+	}
+	members := make([]string, 0, len(set))
+	for member := range set {
+		members = append(members, member)
+	}
+	sort.Strings(members)
+	return tx.Bucket(boltSetBucket).Put([]byte(key), encodeBoltValue([]byte(strings.Join(members, "\n")), expiresAt))
+}
+
+func encodeBoltValue(value []byte, expiresAt time.Time) []byte {
+	raw := make([]byte, 8, 8+len(value))
+	if !expiresAt.IsZero() {
+		binary.BigEndian.PutUint64(raw, uint64(expiresAt.UnixNano()))
+	}
+	return append(raw, value...)
+}
+
+func boltValueExpiresAt(raw []byte) time.Time {
+	expiresAt := binary.BigEndian.Uint64(raw[:8])
+	if expiresAt == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, int64(expiresAt))
+}
+
+func boltValueExpired(raw []byte, now time.Time) bool {
+	expiresAt := boltValueExpiresAt(raw)
+	return !expiresAt.IsZero() && !now.Before(expiresAt)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestBoltStore(t *testing.T, path string) *BoltStore {
+	t.Helper()
+	b, err := NewBoltStore(path)
+	if err != nil {
+		t.Fatalf("NewBoltStore() error = %v", err)
+	}
+	return b
+}
+
+func TestBoltStore_SurvivesRestart(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "codesnap.db")
+
+	b := newTestBoltStore(t, path)
+	if err := b.Set(ctx, "abc1234", "package main", time.Minute); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if _, err := b.Incr(ctx, CodeUploadedCountKey); err != nil {
+		t.Fatalf("Incr() error = %v", err)
+	}
+	if err := b.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	b = newTestBoltStore(t, path)
+	defer b.Close()
+	got, err := b.Get(ctx, "abc1234")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if string(got) != "package main" {
+		t.Errorf("Get() = %q, want %q", got, "package main")
+	}
+	count, err := b.Incr(ctx, CodeUploadedCountKey)
+	if err != nil {
+		t.Fatalf("Incr() error = %v", err)
+	}
+	if count != 2 {
+		t.Errorf("Incr() = %d, want 2", count)
+	}
+}
+
+func TestBoltStore_TTL(t *testing.T) {
+	ctx := context.Background()
+	b := newTestBoltStore(t, filepath.Join(t.TempDir(), "codesnap.db"))
+	defer b.Close()
+
+	if err := b.Set(ctx, "short", "code", 10*time.Millisecond); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if _, err := b.Incr(ctx, "counter"); err != nil {
+		t.Fatalf("Incr() error = %v", err)
+	}
+	if err := b.Expire(ctx, "counter", 10*time.Millisecond); err != nil {
+		t.Fatalf("Expire() error = %v", err)
+	}
+	if err := b.Set(ctx, "long", "code", time.Minute); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	for _, key := range []string{"short", "counter"} {
+		if _, err := b.Get(ctx, key); !errors.Is(err, ErrKeyNotFound) {
+			t.Errorf("Get(%q) error = %v, want %v", key, err, ErrKeyNotFound)
+		}
+	}
+
+	swept, err := b.Sweep()
+	if err != nil {
+		t.Fatalf("Sweep() error = %v", err)
+	}
+	if swept != 2 {
+		t.Errorf("Sweep() = %d, want 2", swept)
+	}
+	if _, err := b.Get(ctx, "long"); err != nil {
+		t.Errorf("Get() error = %v", err)
+	}
+}
@@ -1,11 +1,34 @@
 package main
 
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
 type Command int
 
 const (
 	CmdUnknown Command = iota
 	CmdTunnel
 	CmdTTL
+	CmdGet
+	CmdLang
+	CmdName
+	CmdHelp
+	CmdBurn
+	CmdViews
+	CmdPassword
+	CmdEncrypt
+	CmdList
+	CmdDelete
+	CmdUpdate
+	CmdAppend
+	CmdFiles
 )
 
 func (c Command) String() string {
@@ -14,16 +37,386 @@ func (c Command) String() string {
 		return "tunnel"
 	case CmdTTL:
 		return "ttl"
+	case CmdGet:
+		return "get"
+	case CmdLang:
+		return "lang"
+	case CmdName:
+		return "name"
+	case CmdHelp:
+		return "help"
+	case CmdBurn:
+		return "burn"
+	case CmdViews:
+		return "views"
+	case CmdPassword:
+		return "password"
+	case CmdEncrypt:
+		return "encrypt"
+	case CmdList:
+		return "list"
+	case CmdDelete:
+		return "delete"
+	case CmdUpdate:
+		return "update"
+	case CmdAppend:
+		return "append"
+	case CmdFiles:
+		return "files"
 	}
 	return "unknown"
 }
 
+// IsFlag reports whether the command is used without a value.
+func (c Command) IsFlag() bool {
+	return c == CmdTunnel || c == CmdHelp || c == CmdBurn || c == CmdEncrypt || c == CmdList || c == CmdFiles
+}
+
+// Usage returns how the command is written on the command line.
+func (c Command) Usage() string {
+	switch c {
+	case CmdTunnel:
+		return "tunnel"
+	case CmdTTL:
+		return "ttl=<seconds>"
+	case CmdGet:
+		return "get=<key>"
+	case CmdLang:
+		return "lang=<language>"
+	case CmdName:
+		return "name=<file name>"
+	case CmdHelp:
+		return "help"
+	case CmdBurn:
+		return "burn"
+	case CmdViews:
+		return "views=<count>"
+	case CmdPassword:
+		return "password=<password>"
+	case CmdEncrypt:
+		return "encrypt"
+	case CmdList:
+		return "list"
+	case CmdDelete:
+		return "delete=<key>"
+	case CmdUpdate:
+		return "update=<key>"
+	case CmdAppend:
+		return "append=<key>"
+	case CmdFiles:
+		return "files"
+	}
+	return ""
+}
+
+// Description explains the command, including its limits.
+func (c Command) Description() string {
+	switch c {
+	case CmdTunnel:
+		return fmt.Sprintf("stream stdin live to any number of viewers (max %d MB, link lives %d minutes)",
+			MaxStreamSize/1024/1024, int(TunnelTTL.Minutes()))
+	case CmdTTL:
+		return fmt.Sprintf("how long the snippet lives, between %d and %d seconds (default %d)",
+			int(MinTTL.Seconds()), int(MaxTTL.Seconds()), int(MaxTTL.Seconds()))
+	case CmdGet:
+		return "print a stored snippet, highlighted when run with ssh -t"
+	case CmdLang:
+		return "language used for syntax highlighting, e.g. go or python"
+	case CmdName:
+		return "file name shown on the page and used for downloads"
+	case CmdHelp:
+		return "show this help"
+	case CmdBurn:
+		return "delete the snippet as soon as it has been viewed once"
+	case CmdViews:
+		return "delete the snippet after it has been viewed this many times"
+	case CmdPassword:
+		return "require a password to view the snippet, or to get a protected one"
+	case CmdEncrypt:
+		return "encrypt the snippet, the key is only part of the link and never stored"
+	case CmdList:
+		return "list the snippets uploaded with your ssh key"
+	case CmdDelete:
+		return "delete a snippet uploaded with your ssh key before it expires"
+	case CmdUpdate:
+		return "replace the code of a snippet uploaded with your ssh key, the link stays the same"
+	case CmdAppend:
+		return fmt.Sprintf("add to the end of a snippet uploaded with your ssh key (max %d MB in total)", MaxUploadSize/1024/1024)
+	case CmdFiles:
+		return fmt.Sprintf("upload several files as a tar archive, up to %d files and %d MB in total", MaxFiles, MaxUploadSize/1024/1024)
+	}
+	return ""
+}
+
+// Commands lists the commands in the order they are shown by help.
+var Commands = []Command{CmdTTL, CmdLang, CmdName, CmdBurn, CmdViews, CmdPassword, CmdEncrypt, CmdFiles, CmdTunnel, CmdGet, CmdUpdate, CmdAppend, CmdList, CmdDelete, CmdHelp}
+
+// UploadCommands are the options that only apply to uploaded snippets.
+var UploadCommands = []Command{CmdTTL, CmdLang, CmdName, CmdBurn, CmdViews, CmdPassword, CmdEncrypt, CmdFiles}
+
+// ActionCommands do something other than uploading a snippet, so they can't
+// be combined with each other or with the upload options.
+var ActionCommands = []Command{CmdGet, CmdTunnel, CmdList, CmdDelete, CmdUpdate, CmdAppend}
+
+// actionOptions are the upload options an action can be combined with.
+var actionOptions = map[Command][]Command{
+	// the password of a protected snippet is given along with get
+	CmdGet: {CmdPassword},
+	// the rest of a snippet can't change once it is shared
+	CmdUpdate: {CmdTTL, CmdLang, CmdName},
+}
+
 func ParseCmd(cmd string) Command {
 	switch cmd {
 	case "tunnel":
 		return CmdTunnel
 	case "ttl":
 		return CmdTTL
+	case "get":
+		return CmdGet
+	case "lang":
+		return CmdLang
+	case "name":
+		return CmdName
+	case "help":
+		return CmdHelp
+	case "burn":
+		return CmdBurn
+	case "views":
+		return CmdViews
+	case "password":
+		return CmdPassword
+	case "encrypt":
+		return CmdEncrypt
+	case "list":
+		return CmdList
+	case "delete":
+		return CmdDelete
+	case "update":
+		return CmdUpdate
+	case "append":
+		return CmdAppend
+	case "files":
+		return CmdFiles
 	}
 	return CmdUnknown
 }
+
+const (
+	MaxNameLength     = 255
+	MaxViews          = 10000
+	MaxPasswordLength = 72 // longer passwords can't be hashed with bcrypt
+)
+
+var langRegexp = regexp.MustCompile(`^[a-z0-9][a-z0-9+#._-]{0,31}$`)
+
+// Options holds everything that was set on the ssh command line.
+type Options struct {
+	Tunnel   bool
+	TTL      time.Duration
+	Get      string
+	Lang     string
+	Name     string
+	Help     bool
+	Burn     bool
+	Views    int
+	Password string
+	Encrypt  bool
+	List     bool
+	Delete   string
+	Update   string
+	Append   string
+	Files    bool
+}
+
+// InvalidOptionsError lists every option of a command line that couldn't be
+// parsed, so the user can fix all of them at once.
+type InvalidOptionsError struct {
+	Problems []string
+}
+
+func (e *InvalidOptionsError) Error() string {
+	return strings.Join(e.Problems, "; ")
+}
+
+// ParseOptions parses command line arguments such as
+// `ttl=600 lang=go name=main.go`. Flags can be given without a value.
+func ParseOptions(args []string) (Options, error) {
+	var opts Options
+	var problems []string
+	seen := make(map[Command]bool)
+
+	for _, arg := range args {
+		name, value, hasValue := strings.Cut(arg, "=")
+		cmd := ParseCmd(strings.ToLower(name))
+		if cmd == CmdUnknown {
+			problems = append(problems, fmt.Sprintf("unknown option %q", redactArg(arg)))
+			continue
+		}
+		if seen[cmd] {
+			problems = append(problems, fmt.Sprintf("option %q is set more than once", cmd))
+			continue
+		}
+		seen[cmd] = true
+
+		if err := opts.set(cmd, value, hasValue); err != nil {
+			problems = append(problems, fmt.Sprintf("invalid option %q: %s", redactArg(arg), err))
+		}
+	}
+
+	active := map[Command]bool{
+		CmdGet:    opts.Get != "",
+		CmdTunnel: opts.Tunnel,
+		CmdList:   opts.List,
+		CmdDelete: opts.Delete != "",
+		CmdUpdate: opts.Update != "",
+		CmdAppend: opts.Append != "",
+	}
+	for i, action := range ActionCommands {
+		if !active[action] {
+			continue
+		}
+		for _, cmd := range append(ActionCommands[i+1:], UploadCommands...) {
+			if seen[cmd] && !slices.Contains(actionOptions[action], cmd) {
+				problems = append(problems, fmt.Sprintf("option %q can't be combined with %q", cmd, action))
+			}
+		}
+	}
+	if opts.Burn && opts.Views != 0 {
+		problems = append(problems, fmt.Sprintf("option %q can't be combined with %q", CmdViews, CmdBurn))
+	}
+	if opts.Files {
+		// every file is highlighted by its extension, and decrypted files
+		// couldn't be told apart in the browser
+		for _, cmd := range []Command{CmdLang, CmdEncrypt} {
+			if seen[cmd] {
+				problems = append(problems, fmt.Sprintf("option %q can't be combined with %q", cmd, CmdFiles))
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return Options{}, &InvalidOptionsError{Problems: problems}
+	}
+	return opts, nil
+}
+
+// RedactArgs returns args with the value of password replaced, so command
+// lines can be logged.
+func RedactArgs(args []string) []string {
+	redacted := make([]string, len(args))
+	for i, arg := range args {
+		redacted[i] = redactArg(arg)
+	}
+	return redacted
+}
+
+func redactArg(arg string) string {
+	name, _, hasValue := strings.Cut(arg, "=")
+	if hasValue && ParseCmd(strings.ToLower(name)) == CmdPassword {
+		return name + "=REDACTED"
+	}
+	return arg
+}
+
+func (o *Options) set(cmd Command, value string, hasValue bool) error {
+	if !hasValue && !cmd.IsFlag() {
+		return fmt.Errorf("expected %s", cmd.Usage())
+	}
+	if hasValue && cmd == CmdHelp {
+		return fmt.Errorf("expected %s without a value", cmd)
+	}
+
+	var err error
+	switch cmd {
+	case CmdTunnel:
+		o.Tunnel, err = parseFlag(value, hasValue)
+	case CmdBurn:
+		o.Burn, err = parseFlag(value, hasValue)
+	case CmdEncrypt:
+		o.Encrypt, err = parseFlag(value, hasValue)
+	case CmdList:
+		o.List, err = parseFlag(value, hasValue)
+	case CmdFiles:
+		o.Files, err = parseFlag(value, hasValue)
+	case CmdTTL:
+		seconds, err := strconv.Atoi(value)
+		if err != nil || seconds <= 0 {
+			return fmt.Errorf("expected a positive number of seconds")
+		}
+		o.TTL = time.Duration(seconds) * time.Second
+	case CmdViews:
+		views, err := strconv.Atoi(value)
+		if err != nil || views <= 0 || views > MaxViews {
+			return fmt.Errorf("expected a number of views between 1 and %d", MaxViews)
+		}
+		o.Views = views
+	case CmdPassword:
+		if value == "" || len(value) > MaxPasswordLength {
+			return fmt.Errorf("expected a password of 1 to %d bytes", MaxPasswordLength)
+		}
+		o.Password = value
+	case CmdGet:
+		if value == "" {
+			return fmt.Errorf("expected a snippet key")
+		}
+		o.Get = value
+	case CmdDelete:
+		if value == "" {
+			return fmt.Errorf("expected a snippet key")
+		}
+		o.Delete = value
+	case CmdUpdate:
+		if value == "" {
+			return fmt.Errorf("expected a snippet key")
+		}
+		o.Update = value
+	case CmdAppend:
+		if value == "" {
+			return fmt.Errorf("expected a snippet key")
+		}
+		o.Append = value
+	case CmdLang:
+		value = strings.ToLower(value)
+		if !langRegexp.MatchString(value) {
+			return fmt.Errorf("expected a language name such as go or python")
+		}
+		o.Lang = value
+	case CmdName:
+		name, err := ParseName(value)
+		if err != nil {
+			return err
+		}
+		o.Name = name
+	case CmdHelp:
+		o.Help = true
+	}
+	return err
+}
+
+// parseFlag allows flags to be written as `burn` as well as `burn=true`.
+func parseFlag(value string, hasValue bool) (bool, error) {
+	if !hasValue {
+		return true, nil
+	}
+	flag, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("expected true or false")
+	}
+	return flag, nil
+}
+
+// ParseName validates a user provided file name and strips any directories.
+func ParseName(value string) (string, error) {
+	if strings.ContainsFunc(value, func(r rune) bool { return r < 0x20 || r == 0x7f }) {
+		return "", fmt.Errorf("file name contains control characters")
+	}
+	name := path.Base(strings.ReplaceAll(value, "\\", "/"))
+	if name == "" || name == "." || name == "/" || name == ".." {
+		return "", fmt.Errorf("expected a file name")
+	}
+	if len(name) > MaxNameLength {
+		return "", fmt.Errorf("file name is longer than %d characters", MaxNameLength)
+	}
+	return name, nil
+}
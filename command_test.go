@@ -0,0 +1,172 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseOptions(t *testing.T) {
+	tests := []struct {
+		name         string
+		args         []string
+		want         Options
+		wantProblems []string
+	}{
+		{
+			name: "no options",
+			args: nil,
+			want: Options{},
+		},
+		{
+			name: "legacy single option",
+			args: []string{"tunnel=true"},
+			want: Options{Tunnel: true},
+		},
+		{
+			name: "several options",
+			args: []string{"ttl=600", "lang=Go", "name=cmd/main.go"},
+			want: Options{TTL: 600 * time.Second, Lang: "go", Name: "main.go"},
+		},
+		{
+			name: "flag",
+			args: []string{"tunnel"},
+			want: Options{Tunnel: true},
+		},
+		{
+			name: "help",
+			args: []string{"help"},
+			want: Options{Help: true},
+		},
+		{
+			name: "get",
+			args: []string{"get=abc1234"},
+			want: Options{Get: "abc1234"},
+		},
+		{
+			name: "unknown and malformed options",
+			args: []string{"ttl=soon", "colour=red", "lang", "name=main.go"},
+			wantProblems: []string{
+				`invalid option "ttl=soon": expected a positive number of seconds`,
+				`unknown option "colour=red"`,
+				`invalid option "lang": expected lang=<language>`,
+			},
+		},
+		{
+			name: "duplicate option",
+			args: []string{"ttl=60", "ttl=120"},
+			wantProblems: []string{
+				`option "ttl" is set more than once`,
+			},
+		},
+		{
+			name: "view limit",
+			args: []string{"views=5"},
+			want: Options{Views: 5},
+		},
+		{
+			name: "invalid view limit",
+			args: []string{"views=0"},
+			wantProblems: []string{
+				`invalid option "views=0": expected a number of views between 1 and 10000`,
+			},
+		},
+		{
+			name: "get a protected snippet",
+			args: []string{"get=abc1234", "password=hunter2"},
+			want: Options{Get: "abc1234", Password: "hunter2"},
+		},
+		{
+			name: "password too long",
+			args: []string{"Password=" + strings.Repeat("x", MaxPasswordLength+1)},
+			wantProblems: []string{
+				`invalid option "Password=REDACTED": expected a password of 1 to 72 bytes`,
+			},
+		},
+		{
+			name: "burn with a view limit",
+			args: []string{"burn", "views=5"},
+			wantProblems: []string{
+				`option "views" can't be combined with "burn"`,
+			},
+		},
+		{
+			name: "delete",
+			args: []string{"delete=abc1234"},
+			want: Options{Delete: "abc1234"},
+		},
+		{
+			name: "update",
+			args: []string{"update=abc1234", "ttl=600", "lang=go"},
+			want: Options{Update: "abc1234", TTL: 600 * time.Second, Lang: "go"},
+		},
+		{
+			name: "update a burn snippet",
+			args: []string{"update=abc1234", "burn"},
+			wantProblems: []string{
+				`option "burn" can't be combined with "update"`,
+			},
+		},
+		{
+			name: "files",
+			args: []string{"files", "ttl=600", "name=src"},
+			want: Options{Files: true, TTL: 600 * time.Second, Name: "src"},
+		},
+		{
+			name: "files with a language",
+			args: []string{"files", "lang=go"},
+			wantProblems: []string{
+				`option "lang" can't be combined with "files"`,
+			},
+		},
+		{
+			name: "list with another action",
+			args: []string{"list", "delete=abc1234", "ttl=60"},
+			wantProblems: []string{
+				`option "delete" can't be combined with "list"`,
+				`option "ttl" can't be combined with "list"`,
+				`option "ttl" can't be combined with "delete"`,
+			},
+		},
+		{
+			name: "conflicting options",
+			args: []string{"get=abc1234", "lang=go"},
+			wantProblems: []string{
+				`option "lang" can't be combined with "get"`,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseOptions(tt.args)
+			if tt.wantProblems == nil {
+				if err != nil {
+					t.Fatalf("ParseOptions() error = %v", err)
+				}
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("ParseOptions() = %+v, want %+v", got, tt.want)
+				}
+				return
+			}
+
+			var optionsErr *InvalidOptionsError
+			if !errors.As(err, &optionsErr) {
+				t.Fatalf("ParseOptions() error = %v, want %T", err, optionsErr)
+			}
+			if !reflect.DeepEqual(optionsErr.Problems, tt.wantProblems) {
+				t.Errorf("problems = %q, want %q", optionsErr.Problems, tt.wantProblems)
+			}
+		})
+	}
+}
+
+func TestRedactArgs(t *testing.T) {
+	got := RedactArgs([]string{"get=abc1234", "password=hunter2", "PASSWORD=a=b", "password"})
+	want := []string{"get=abc1234", "password=REDACTED", "PASSWORD=REDACTED", "password"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("RedactArgs() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// CompressionThreshold is the size from which values are compressed,
+	// smaller ones rarely get any smaller.
+	CompressionThreshold         = 512
+	CompressedStoreStatsInterval = 10 * time.Minute
+)
+
+// compressedValueMagic starts every value compressed by a CompressedStore
+// and is followed by the gzip stream.
+var compressedValueMagic = []byte("\x00csz")
+
+// CompressedStore gzips values of at least CompressionThreshold bytes before
+// they reach the wrapped store. Values without the format marker, such as
+// small ones or the ones written before compression was enabled, are read
+// as they are. Integers are never touched, the backend increments them.
+type CompressedStore struct {
+	store Store
+	stats struct {
+		values           atomic.Int64
+		compressedValues atomic.Int64
+		originalBytes    atomic.Int64
+		storedBytes      atomic.Int64
+	}
+}
+
+func NewCompressedStore(store Store) *CompressedStore {
+	return &CompressedStore{store: store}
+}
+
+type CompressionStats struct {
+	Values           int64
+	CompressedValues int64
+	OriginalBytes    int64
+	StoredBytes      int64
+}
+
+// Ratio returns how many bytes were written for every stored byte.
+func (s CompressionStats) Ratio() float64 {
+	if s.StoredBytes == 0 {
+		return 1
+	}
+	return float64(s.OriginalBytes) / float64(s.StoredBytes)
+}
+
+// Stats returns what has been written since the store was created.
+func (c *CompressedStore) Stats() CompressionStats {
+	return CompressionStats{
+		Values:           c.stats.values.Load(),
+		CompressedValues: c.stats.compressedValues.Load(),
+		OriginalBytes:    c.stats.originalBytes.Load(),
+		StoredBytes:      c.stats.storedBytes.Load(),
+	}
+}
+
+func (c *CompressedStore) Set(ctx context.Context, key string, value any, ttl time.Duration) error {
+	switch value.(type) {
+	case int, int64, uint64:
+		return c.store.Set(ctx, key, value, ttl)
+	}
+	b, err := valueToBytes(value)
+	if err != nil {
+		return err
+	}
+	stored, err := c.compress(b)
+	if err != nil {
+		return err
+	}
+	return c.store.Set(ctx, key, stored, ttl)
+}
+
+func (c *CompressedStore) Get(ctx context.Context, key string) ([]byte, error) {
+	raw, err := c.store.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return decompress(raw)
+}
+
+func (c *CompressedStore) GetDel(ctx context.Context, key string) ([]byte, error) {
+	raw, err := c.store.GetDel(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return decompress(raw)
+}
+
+func (c *CompressedStore) Del(ctx context.Context, key string) error {
+	return c.store.Del(ctx, key)
+}
+
+func (c *CompressedStore) Incr(ctx context.Context, key string) (int64, error) {
+	return c.store.Incr(ctx, key)
+}
+
+func (c *CompressedStore) Decr(ctx context.Context, key string) (int64, error) {
+	return c.store.Decr(ctx, key)
+}
+
+func (c *CompressedStore) Expire(ctx context.Context, key string, ttl time.Duration) error {
+	return c.store.Expire(ctx, key, ttl)
+}
+
+// set members are keys, which are stored as they are
+
+func (c *CompressedStore) SAdd(ctx context.Context, key string, members ...string) error {
+	return c.store.SAdd(ctx, key, members...)
+}
+
+func (c *CompressedStore) SRem(ctx context.Context, key string, members ...string) error {
+	return c.store.SRem(ctx, key, members...)
+}
+
+func (c *CompressedStore) SMembers(ctx context.Context, key string) ([]string, error) {
+	return c.store.SMembers(ctx, key)
+}
+
+func (c *CompressedStore) compress(value []byte) ([]byte, error) {
+	c.stats.values.Add(1)
+	c.stats.originalBytes.Add(int64(len(value)))
+
+	// a small value that looks compressed has to be compressed to read back as it is
+	if len(value) < CompressionThreshold && !bytes.HasPrefix(value, compressedValueMagic) {
+		c.stats.storedBytes.Add(int64(len(value)))
+		return value, nil
+	}
+
+	var buf bytes.Buffer
+	buf.Write(compressedValueMagic)
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(value); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	if buf.Len() >= len(value) && !bytes.HasPrefix(value, compressedValueMagic) {
+		c.stats.storedBytes.Add(int64(len(value)))
+		return value, nil
+	}
+
+	c.stats.compressedValues.Add(1)
+	c.stats.storedBytes.Add(int64(buf.Len()))
+	return buf.Bytes(), nil
+}
+
+func decompress(raw []byte) ([]byte, error) {
+	if !bytes.HasPrefix(raw, compressedValueMagic) {
+		return raw, nil
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(raw[len(compressedValueMagic):]))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	return io.ReadAll(zr)
+}
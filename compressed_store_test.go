@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCompressedStore_SetGet(t *testing.T) {
+	tests := []struct {
+		name           string
+		value          string
+		wantCompressed bool
+	}{
+		{name: "small", value: "fmt.Println()", wantCompressed: false},
+		{name: "large", value: strings.Repeat("fmt.Println(\"hello\")\n", 100), wantCompressed: true},
+		{name: "small with marker", value: "\x00cszNot compressed", wantCompressed: true},
+	}
+
+	ctx := context.Background()
+	backend := NewMemoryStore()
+	c := NewCompressedStore(backend)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := c.Set(ctx, tt.name, tt.value, time.Minute); err != nil {
+				t.Fatalf("Set() error = %v", err)
+			}
+			raw, err := backend.Get(ctx, tt.name)
+			if err != nil {
+				t.Fatalf("Get() error = %v", err)
+			}
+			if compressed := string(raw) != tt.value; compressed != tt.wantCompressed {
+				t.Errorf("compressed = %v, want %v", compressed, tt.wantCompressed)
+			}
+			got, err := c.Get(ctx, tt.name)
+			if err != nil {
+				t.Fatalf("Get() error = %v", err)
+			}
+			if string(got) != tt.value {
+				t.Errorf("Get() = %q, want %q", got, tt.value)
+			}
+		})
+	}
+
+	stats := c.Stats()
+	if stats.Values != 3 || stats.CompressedValues != 2 || stats.Ratio() <= 1 {
+		t.Errorf("Stats() = %+v, ratio %.2f", stats, stats.Ratio())
+	}
+}
+
+func TestCompressedStore_ReadsUncompressedValues(t *testing.T) {
+	ctx := context.Background()
+	backend := NewMemoryStore()
+	value := strings.Repeat("written before compression\n", 100)
+	if err := backend.Set(ctx, "old", value, time.Minute); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+
+	got, err := NewCompressedStore(backend).Get(ctx, "old")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if string(got) != value {
+		t.Errorf("Get() = %q, want %q", got, value)
+	}
+}
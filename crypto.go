@@ -0,0 +1,64 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+)
+
+// SnippetSecretSize is the size of the AES-256 key of an encrypted snippet.
+const SnippetSecretSize = 32
+
+// SealSnippet encrypts code with a new random key using AES-256-GCM. The
+// sealed snippet is the nonce followed by the ciphertext. The key is
+// returned URL safe base64 encoded and must only ever end up in the
+// fragment of the link, so the stored snippet is useless on its own.
+func SealSnippet(code []byte) ([]byte, string, error) {
+	key := make([]byte, SnippetSecretSize)
+	_, err := rand.Read(key)
+	if err != nil {
+		return nil, "", err
+	}
+	sealed, err := sealAESGCM(key, code, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	return sealed, base64.RawURLEncoding.EncodeToString(key), nil
+}
+
+// sealAESGCM encrypts plaintext with key. Opening it takes the same
+// additional data, which isn't stored with it.
+func sealAESGCM(key, plaintext, additionalData []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize(), gcm.NonceSize()+len(plaintext)+gcm.Overhead())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func openAESGCM(key, sealed, additionalData []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("sealed value is too short")
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, additionalData)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"testing"
+)
+
+var errInvalidSecret = errors.New("invalid snippet secret")
+
+// openSnippet decrypts a snippet sealed by SealSnippet, the same way the
+// snippet page does in the browser.
+func openSnippet(sealed []byte, secret string) ([]byte, error) {
+	key, err := base64.RawURLEncoding.DecodeString(secret)
+	if err != nil || len(key) != SnippetSecretSize {
+		return nil, errInvalidSecret
+	}
+	return openAESGCM(key, sealed, nil)
+}
+
+func TestSealSnippet(t *testing.T) {
+	code := []byte("const apiKey = \"internal\"")
+
+	sealed, secret, err := SealSnippet(code)
+	if err != nil {
+		t.Fatalf("SealSnippet() error = %v", err)
+	}
+	if bytes.Contains(sealed, code) {
+		t.Fatalf("sealed snippet contains the code")
+	}
+
+	got, err := openSnippet(sealed, secret)
+	if err != nil {
+		t.Fatalf("openSnippet() error = %v", err)
+	}
+	if !bytes.Equal(got, code) {
+		t.Errorf("openSnippet() = %q, want %q", got, code)
+	}
+
+	_, otherSecret, err := SealSnippet(code)
+	if err != nil {
+		t.Fatalf("SealSnippet() error = %v", err)
+	}
+	if _, err := openSnippet(sealed, otherSecret); err == nil {
+		t.Errorf("openSnippet() with another secret succeeded")
+	}
+	if _, err := openSnippet(sealed, "not-a-secret"); !errors.Is(err, errInvalidSecret) {
+		t.Errorf("openSnippet() error = %v, want %v", err, errInvalidSecret)
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// DiffContext is the number of unchanged lines shown around a change.
+	DiffContext = 3
+	// MaxDiffEdits bounds the work spent on a diff. Files that differ in
+	// more lines are shown as replaced as a whole.
+	MaxDiffEdits = 1000
+)
+
+type DiffKind byte
+
+const (
+	DiffEqual  DiffKind = ' '
+	DiffDelete DiffKind = '-'
+	DiffInsert DiffKind = '+'
+)
+
+func (k DiffKind) String() string {
+	switch k {
+	case DiffDelete:
+		return "delete"
+	case DiffInsert:
+		return "insert"
+	}
+	return "equal"
+}
+
+type DiffLine struct {
+	Kind DiffKind
+	Text string
+}
+
+// DiffHunk is a run of changes along with their context, as in a unified
+// diff. Start lines count from 1.
+type DiffHunk struct {
+	OldStart, OldLines int
+	NewStart, NewLines int
+	Lines              []DiffLine
+}
+
+func (h DiffHunk) Header() string {
+	return fmt.Sprintf("@@ -%s +%s @@", hunkRange(h.OldStart, h.OldLines), hunkRange(h.NewStart, h.NewLines))
+}
+
+func hunkRange(start, lines int) string {
+	if lines == 0 {
+		// an empty range points at the line before it
+		return fmt.Sprintf("%d,0", start-1)
+	}
+	if lines == 1 {
+		return fmt.Sprintf("%d", start)
+	}
+	return fmt.Sprintf("%d,%d", start, lines)
+}
+
+// Diff compares two texts line by line and returns the hunks of a unified
+// diff, none if they are equal.
+func Diff(oldText, newText string) []DiffHunk {
+	return diffHunks(diffLines(splitLines(oldText), splitLines(newText)), DiffContext)
+}
+
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+// diffLines returns the edit script turning a into b. Common lines at both
+// ends are skipped, the rest is compared with Myers' algorithm.
+func diffLines(a, b []string) []DiffLine {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	lines := make([]DiffLine, 0, len(a)+len(b))
+	for _, text := range a[:prefix] {
+		lines = append(lines, DiffLine{Kind: DiffEqual, Text: text})
+	}
+	lines = append(lines, myersDiff(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+	for _, text := range a[len(a)-suffix:] {
+		lines = append(lines, DiffLine{Kind: DiffEqual, Text: text})
+	}
+	return lines
+}
+
+// myersDiff finds the shortest edit script, see "An O(ND) Difference
+// Algorithm and Its Variations". Only the part of every step that can be
+// reached is kept for backtracking, which bounds memory by MaxDiffEdits².
+func myersDiff(a, b []string) []DiffLine {
+	n, m := len(a), len(b)
+	offset := n + m + 1
+	v := make([]int, 2*offset+1)
+	var trace [][]int
+
+	found := false
+	for d := 0; d <= min(n+m, MaxDiffEdits) && !found; d++ {
+		// the furthest x on the diagonals d-1 steps can reach, around k = 0
+		trace = append(trace, append([]int(nil), v[offset-d-1:offset+d+2]...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				found = true
+				break
+			}
+		}
+	}
+	if !found {
+		lines := make([]DiffLine, 0, n+m)
+		for _, text := range a {
+			lines = append(lines, DiffLine{Kind: DiffDelete, Text: text})
+		}
+		for _, text := range b {
+			lines = append(lines, DiffLine{Kind: DiffInsert, Text: text})
+		}
+		return lines
+	}
+
+	var reversed []DiffLine
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		prev := func(k int) int { return trace[d][k+d+1] }
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && prev(k-1) < prev(k+1)) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := prev(prevK)
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			reversed = append(reversed, DiffLine{Kind: DiffEqual, Text: a[x-1]})
+			x--
+			y--
+		}
+		if d > 0 {
+			if x == prevX {
+				reversed = append(reversed, DiffLine{Kind: DiffInsert, Text: b[y-1]})
+			} else {
+				reversed = append(reversed, DiffLine{Kind: DiffDelete, Text: a[x-1]})
+			}
+		}
+		x, y = prevX, prevY
+	}
+
+	lines := make([]DiffLine, len(reversed))
+	for i, line := range reversed {
+		lines[len(lines)-1-i] = line
+	}
+	return lines
+}
+
+// diffHunks groups the changes of an edit script into hunks with up to
+// context unchanged lines around them. Changes that are closer than twice
+// the context share a hunk.
+func diffHunks(lines []DiffLine, context int) []DiffHunk {
+	// the ranges of lines shown, as [start, end) indexes into lines
+	var ranges [][2]int
+	for i, line := range lines {
+		if line.Kind == DiffEqual {
+			continue
+		}
+		start, end := max(i-context, 0), min(i+context+1, len(lines))
+		if len(ranges) > 0 && start <= ranges[len(ranges)-1][1] {
+			ranges[len(ranges)-1][1] = end
+			continue
+		}
+		ranges = append(ranges, [2]int{start, end})
+	}
+
+	var hunks []DiffHunk
+	oldLine, newLine, next := 1, 1, 0
+	for _, r := range ranges {
+		for _, line := range lines[next:r[0]] {
+			oldLine, newLine = advanceLines(line, oldLine, newLine)
+		}
+		hunk := DiffHunk{OldStart: oldLine, NewStart: newLine, Lines: lines[r[0]:r[1]]}
+		for _, line := range hunk.Lines {
+			if line.Kind != DiffInsert {
+				hunk.OldLines++
+			}
+			if line.Kind != DiffDelete {
+				hunk.NewLines++
+			}
+			oldLine, newLine = advanceLines(line, oldLine, newLine)
+		}
+		hunks = append(hunks, hunk)
+		next = r[1]
+	}
+	return hunks
+}
+
+func advanceLines(line DiffLine, oldLine, newLine int) (int, int) {
+	switch line.Kind {
+	case DiffDelete:
+		return oldLine + 1, newLine
+	case DiffInsert:
+		return oldLine, newLine + 1
+	}
+	return oldLine + 1, newLine + 1
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const EncryptedStoreRotateInterval = time.Hour
+
+// encryptedValueMagic starts every value written by an EncryptedStore. It is
+// followed by the 4 byte key version and the AES-GCM sealed value.
+var encryptedValueMagic = []byte("\x00cse")
+
+var ErrUnknownKeyVersion = errors.New("value is encrypted with an unknown key version")
+
+// Keyring holds the master keys of an EncryptedStore by version. New values
+// are always encrypted with the highest version, older versions are kept to
+// read values until they are rotated.
+type Keyring struct {
+	keys    map[uint32][]byte
+	current uint32
+}
+
+// ParseKeyring parses keys written as `version:base64 key`, separated by
+// commas or new lines, e.g. `1:c2VjcmV0...,2:bmV3ZXI...`.
+func ParseKeyring(s string) (*Keyring, error) {
+	keyring := &Keyring{keys: make(map[uint32][]byte)}
+	for _, entry := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == '\n' }) {
+		entry = strings.TrimSpace(entry)
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+		version, encoded, ok := strings.Cut(entry, ":")
+		if !ok {
+			return nil, fmt.Errorf("expected version:key, got %q", entry)
+		}
+		v, err := strconv.ParseUint(version, 10, 32)
+		if err != nil || v == 0 {
+			return nil, fmt.Errorf("invalid key version %q", version)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("key version %d is not a base64 encoded 32 byte key", v)
+		}
+		if _, ok := keyring.keys[uint32(v)]; ok {
+			return nil, fmt.Errorf("key version %d is set more than once", v)
+		}
+		keyring.keys[uint32(v)] = key
+		keyring.current = max(keyring.current, uint32(v))
+	}
+	if len(keyring.keys) == 0 {
+		return nil, errors.New("no keys")
+	}
+	return keyring, nil
+}
+
+// Versions returns the key versions, oldest first.
+func (k *Keyring) Versions() []uint32 {
+	versions := make([]uint32, 0, len(k.keys))
+	for v := range k.keys {
+		versions = append(versions, v)
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i] < versions[j] })
+	return versions
+}
+
+// EncryptedStore encrypts every value before it reaches the wrapped store,
+// so whoever runs the backend can't read the snippets. Integers are stored
+// as they are: they are counters, which the backend has to increment.
+// Values written before encryption was enabled are read as they are, and
+// Rotate re-encrypts them along with values of older key versions.
+type EncryptedStore struct {
+	store   RewritableStore
+	keyring *Keyring
+}
+
+func NewEncryptedStore(store RewritableStore, keyring *Keyring) *EncryptedStore {
+	return &EncryptedStore{
+		store:   store,
+		keyring: keyring,
+	}
+}
+
+func (e *EncryptedStore) Set(ctx context.Context, key string, value any, ttl time.Duration) error {
+	switch value.(type) {
+	case int, int64, uint64:
+		return e.store.Set(ctx, key, value, ttl)
+	}
+	b, err := valueToBytes(value)
+	if err != nil {
+		return err
+	}
+	encrypted, err := e.encrypt(key, b)
+	if err != nil {
+		return err
+	}
+	return e.store.Set(ctx, key, encrypted, ttl)
+}
+
+func (e *EncryptedStore) Get(ctx context.Context, key string) ([]byte, error) {
+	raw, err := e.store.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return e.decrypt(key, raw)
+}
+
+func (e *EncryptedStore) GetDel(ctx context.Context, key string) ([]byte, error) {
+	raw, err := e.store.GetDel(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return e.decrypt(key, raw)
+}
+
+func (e *EncryptedStore) Del(ctx context.Context, key string) error {
+	return e.store.Del(ctx, key)
+}
+
+func (e *EncryptedStore) Incr(ctx context.Context, key string) (int64, error) {
+	return e.store.Incr(ctx, key)
+}
+
+func (e *EncryptedStore) Decr(ctx context.Context, key string) (int64, error) {
+	return e.store.Decr(ctx, key)
+}
+
+func (e *EncryptedStore) Expire(ctx context.Context, key string, ttl time.Duration) error {
+	return e.store.Expire(ctx, key, ttl)
+}
+
+// set members are keys, which are stored as they are
+
+func (e *EncryptedStore) SAdd(ctx context.Context, key string, members ...string) error {
+	return e.store.SAdd(ctx, key, members...)
+}
+
+func (e *EncryptedStore) SRem(ctx context.Context, key string, members ...string) error {
+	return e.store.SRem(ctx, key, members...)
+}
+
+func (e *EncryptedStore) SMembers(ctx context.Context, key string) ([]string, error) {
+	return e.store.SMembers(ctx, key)
+}
+
+// Rotate re-encrypts every value that isn't encrypted with the current key
+// yet and returns how many were rewritten and how many were skipped. Values
+// that can't be decrypted, e.g. because their key was dropped, are passed
+// to skipped and left as they are so one of them doesn't stop the rotation.
+// Values that change while they are rotated are left alone, the next run
+// picks them up if needed.
+func (e *EncryptedStore) Rotate(ctx context.Context, skipped func(key string, err error)) (uint, uint, error) {
+	rotatedCount := uint(0)
+	skippedCount := uint(0)
+	err := e.store.Scan(ctx, func(key string) error {
+		raw, err := e.store.Get(ctx, key)
+		if errors.Is(err, ErrKeyNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		version, ok := encryptedValueVersion(raw)
+		if ok && version == e.keyring.current {
+			return nil
+		}
+		if !ok {
+			if _, err := strconv.ParseInt(string(raw), 10, 64); err == nil {
+				return nil
+			}
+		}
+
+		value, err := e.decrypt(key, raw)
+		if err != nil {
+			skippedCount++
+			if skipped != nil {
+				skipped(key, err)
+			}
+			return nil
+		}
+		encrypted, err := e.encrypt(key, value)
+		if err != nil {
+			return err
+		}
+		swapped, err := e.store.CompareAndSwap(ctx, key, raw, encrypted)
+		if err != nil {
+			return err
+		}
+		if swapped {
+			rotatedCount++
+		}
+		return nil
+	})
+	return rotatedCount, skippedCount, err
+}
+
+// encrypt seals value with the current key. The store key is passed as
+// additional data, so a value copied to another key fails to decrypt.
+func (e *EncryptedStore) encrypt(key string, value []byte) ([]byte, error) {
+	sealed, err := sealAESGCM(e.keyring.keys[e.keyring.current], value, []byte(key))
+	if err != nil {
+		return nil, err
+	}
+	raw := make([]byte, 0, len(encryptedValueMagic)+4+len(sealed))
+	raw = append(raw, encryptedValueMagic...)
+	raw = binary.BigEndian.AppendUint32(raw, e.keyring.current)
+	return append(raw, sealed...), nil
+}
+
+func (e *EncryptedStore) decrypt(key string, raw []byte) ([]byte, error) {
+	version, ok := encryptedValueVersion(raw)
+	if !ok {
+		return raw, nil
+	}
+	masterKey, ok := e.keyring.keys[version]
+	if !ok {
+		return nil, fmt.Errorf("%w %d", ErrUnknownKeyVersion, version)
+	}
+	return openAESGCM(masterKey, raw[len(encryptedValueMagic)+4:], []byte(key))
+}
+
+func encryptedValueVersion(raw []byte) (uint32, bool) {
+	if len(raw) < len(encryptedValueMagic)+4 || !bytes.HasPrefix(raw, encryptedValueMagic) {
+		return 0, false
+	}
+	return binary.BigEndian.Uint32(raw[len(encryptedValueMagic):]), true
+}
+
+// ReadKeyringFile reads a keyring from a file, one key per line.
+func ReadKeyringFile(path string) (*Keyring, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseKeyring(string(b))
+}
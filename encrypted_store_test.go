@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testKeyring(t *testing.T, versions ...string) *Keyring {
+	t.Helper()
+	var entries []string
+	for _, v := range versions {
+		key := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte(v), 32))
+		entries = append(entries, v+":"+key)
+	}
+	keyring, err := ParseKeyring(strings.Join(entries, ","))
+	if err != nil {
+		t.Fatalf("ParseKeyring() error = %v", err)
+	}
+	return keyring
+}
+
+func TestParseKeyring(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString(make([]byte, 32))
+	tests := []struct {
+		name    string
+		keys    string
+		current uint32
+		wantErr bool
+	}{
+		{name: "single key", keys: "1:" + key, current: 1},
+		{name: "rotated keys", keys: "1:" + key + "\n# new key\n3:" + key + "\n", current: 3},
+		{name: "no keys", keys: "\n", wantErr: true},
+		{name: "missing version", keys: key, wantErr: true},
+		{name: "short key", keys: "1:c2VjcmV0", wantErr: true},
+		{name: "duplicate version", keys: "1:" + key + ",1:" + key, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keyring, err := ParseKeyring(tt.keys)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("ParseKeyring() error = nil, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseKeyring() error = %v", err)
+			}
+			if keyring.current != tt.current {
+				t.Errorf("current version = %d, want %d", keyring.current, tt.current)
+			}
+		})
+	}
+}
+
+func TestEncryptedStore_SetGet(t *testing.T) {
+	ctx := context.Background()
+	backend := NewMemoryStore()
+	e := NewEncryptedStore(backend, testKeyring(t, "1"))
+
+	if err := e.Set(ctx, "abc1234", "package main", time.Minute); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	raw, err := backend.Get(ctx, "abc1234")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if bytes.Contains(raw, []byte("package main")) {
+		t.Errorf("backend stores the value in plaintext")
+	}
+	got, err := e.Get(ctx, "abc1234")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if string(got) != "package main" {
+		t.Errorf("Get() = %q, want %q", got, "package main")
+	}
+
+	// a value copied to another key doesn't decrypt there
+	if err := backend.Set(ctx, "def5678", raw, time.Minute); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if got, err := e.Get(ctx, "def5678"); err == nil {
+		t.Errorf("Get() of a copied value = %q, want an error", got)
+	}
+
+	// counters stay usable by the backend
+	if err := e.Set(ctx, "views:abc1234", 2, time.Minute); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if left, err := e.Decr(ctx, "views:abc1234"); err != nil || left != 1 {
+		t.Errorf("Decr() = %d, %v, want 1", left, err)
+	}
+}
+
+func TestEncryptedStore_Rotate(t *testing.T) {
+	ctx := context.Background()
+	backend := NewMemoryStore()
+
+	if err := backend.Set(ctx, "plain", "written before encryption", time.Minute); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if err := NewEncryptedStore(backend, testKeyring(t, "1")).Set(ctx, "old", "old key", time.Minute); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if _, err := backend.Incr(ctx, "counter"); err != nil {
+		t.Fatalf("Incr() error = %v", err)
+	}
+
+	// written with a key that was dropped since, it can't be rotated
+	if err := NewEncryptedStore(backend, testKeyring(t, "3")).Set(ctx, "lost", "lost key", time.Minute); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+
+	e := NewEncryptedStore(backend, testKeyring(t, "1", "2"))
+	var skippedKeys []string
+	rotated, skipped, err := e.Rotate(ctx, func(key string, err error) {
+		if !errors.Is(err, ErrUnknownKeyVersion) {
+			t.Errorf("skipped %q with error = %v, want %v", key, err, ErrUnknownKeyVersion)
+		}
+		skippedKeys = append(skippedKeys, key)
+	})
+	if err != nil {
+		t.Fatalf("Rotate() error = %v", err)
+	}
+	if rotated != 2 || skipped != 1 {
+		t.Errorf("Rotate() = %d, %d, want 2, 1", rotated, skipped)
+	}
+	if len(skippedKeys) != 1 || skippedKeys[0] != "lost" {
+		t.Errorf("skipped keys = %q, want [lost]", skippedKeys)
+	}
+
+	want := map[string]string{"plain": "written before encryption", "old": "old key", "counter": "1"}
+	for key, value := range want {
+		raw, err := backend.Get(ctx, key)
+		if err != nil {
+			t.Fatalf("Get(%q) error = %v", key, err)
+		}
+		if version, ok := encryptedValueVersion(raw); key != "counter" && (!ok || version != 2) {
+			t.Errorf("%q is encrypted with version %d after rotating", key, version)
+		}
+		got, err := e.Get(ctx, key)
+		if err != nil {
+			t.Fatalf("Get(%q) error = %v", key, err)
+		}
+		if string(got) != value {
+			t.Errorf("Get(%q) = %q, want %q", key, got, value)
+		}
+	}
+
+	// once rotated the old key can be dropped
+	e = NewEncryptedStore(backend, testKeyring(t, "2"))
+	if _, err := e.Get(ctx, "old"); err != nil {
+		t.Errorf("Get() error = %v", err)
+	}
+	if _, err := NewEncryptedStore(backend, testKeyring(t, "3")).Get(ctx, "old"); !errors.Is(err, ErrUnknownKeyVersion) {
+		t.Errorf("Get() error = %v, want %v", err, ErrUnknownKeyVersion)
+	}
+}
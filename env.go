@@ -1,6 +1,8 @@
 package main
 
 import (
+	"fmt"
+	"net/netip"
 	"os"
 	"strconv"
 )
@@ -19,6 +21,114 @@ func IsDev() bool {
 	return os.Getenv("ENV") == "dev" || os.Getenv("ENV") == ""
 }
 
+const (
+	StoreBackendRedis  = "redis"
+	StoreBackendMemory = "memory"
+	StoreBackendBolt   = "bolt"
+)
+
+func GetStoreBackendOrPanic() string {
+	backend := os.Getenv("STORE")
+	switch backend {
+	case "":
+		return StoreBackendRedis
+	case StoreBackendRedis, StoreBackendMemory, StoreBackendBolt:
+		return backend
+	}
+	panic("STORE is not a valid store backend")
+}
+
+const (
+	OversizedUploadsTruncate = "truncate"
+	OversizedUploadsReject   = "reject"
+)
+
+// GetOversizedUploadsOrPanic returns what happens to uploads larger than
+// MaxUploadSize: they are either truncated (the default) or rejected.
+func GetOversizedUploadsOrPanic() string {
+	mode := os.Getenv("OVERSIZED_UPLOADS")
+	switch mode {
+	case "":
+		return OversizedUploadsTruncate
+	case OversizedUploadsTruncate, OversizedUploadsReject:
+		return mode
+	}
+	panic("OVERSIZED_UPLOADS is not a valid mode")
+}
+
+// GetMaxRevisionsOrPanic returns how many previous revisions of an updated
+// snippet are kept, DefaultMaxRevisions unless MAX_REVISIONS is set. 0 keeps
+// no history.
+func GetMaxRevisionsOrPanic() int {
+	revisions := os.Getenv("MAX_REVISIONS")
+	if revisions == "" {
+		return DefaultMaxRevisions
+	}
+	revisionsInt, err := strconv.Atoi(revisions)
+	if err != nil || revisionsInt < 0 {
+		panic("MAX_REVISIONS is not a valid number of revisions")
+	}
+	return revisionsInt
+}
+
+const (
+	CompressionNone = "none"
+	CompressionGzip = "gzip"
+)
+
+// GetCompressionOrPanic returns how stored values are compressed: not at
+// all (the default) or with gzip.
+func GetCompressionOrPanic() string {
+	compression := os.Getenv("COMPRESSION")
+	switch compression {
+	case "":
+		return CompressionNone
+	case CompressionNone, CompressionGzip:
+		return compression
+	}
+	panic("COMPRESSION is not a valid compression")
+}
+
+// GetEncryptionKeyringOrPanic returns the master keys used to encrypt the
+// store at rest, read from ENCRYPTION_KEYS or from the file ENCRYPTION_KEYS_FILE.
+// It returns nil when neither is set, which leaves the store unencrypted.
+func GetEncryptionKeyringOrPanic() *Keyring {
+	if keys := os.Getenv("ENCRYPTION_KEYS"); keys != "" {
+		keyring, err := ParseKeyring(keys)
+		if err != nil {
+			panic(fmt.Sprintf("ENCRYPTION_KEYS is not valid: %s", err))
+		}
+		return keyring
+	}
+	if path := os.Getenv("ENCRYPTION_KEYS_FILE"); path != "" {
+		keyring, err := ReadKeyringFile(path)
+		if err != nil {
+			panic(fmt.Sprintf("ENCRYPTION_KEYS_FILE is not valid: %s", err))
+		}
+		return keyring
+	}
+	return nil
+}
+
+// GetTrustedProxiesOrPanic returns the proxies whose X-Real-IP header is
+// trusted, from the addresses and CIDR ranges in TRUSTED_PROXIES. Without
+// any the header is ignored.
+func GetTrustedProxiesOrPanic() []netip.Prefix {
+	proxies, err := ParseTrustedProxies(os.Getenv("TRUSTED_PROXIES"))
+	if err != nil {
+		panic(fmt.Sprintf("TRUSTED_PROXIES is not valid: %s", err))
+	}
+	return proxies
+}
+
+func GetBoltPathOrPanic() string {
+	path := os.Getenv("BOLT_PATH")
+	if path == "" {
+		panic("BOLT_PATH is not set")
+	}
+	return path
+}
+
 func GetRedisHostOrPanic() string {
 	host := os.Getenv("REDIS_HOST")
 	if host == "" {
@@ -3,18 +3,23 @@ module github.com/gigaxel/codesnap.sh
 go 1.22
 
 require (
-	github.com/gliderlabs/ssh v0.3.5
+	github.com/alecthomas/chroma/v2 v2.24.1
+	github.com/gliderlabs/ssh v0.3.8
 	github.com/google/uuid v1.3.0
+	github.com/joho/godotenv v1.5.1
+	github.com/pkg/sftp v1.13.9
 	github.com/redis/go-redis/v9 v9.0.5
+	go.etcd.io/bbolt v1.3.8
 	go.uber.org/zap v1.25.0
+	golang.org/x/crypto v0.31.0
 )
 
 require (
 	github.com/anmitsu/go-shlex v0.0.0-20200514113438-38f4b401e2be // indirect
 	github.com/cespare/xxhash/v2 v2.2.0 // indirect
 	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
-	github.com/joho/godotenv v1.5.1 //indirect
+	github.com/dlclark/regexp2 v1.12.0 // indirect
+	github.com/kr/fs v0.1.0 // indirect
 	go.uber.org/multierr v1.10.0 // indirect
-	golang.org/x/crypto v0.0.0-20220826181053-bd7e27e6170d // indirect
-	golang.org/x/sys v0.11.0 // indirect
+	golang.org/x/sys v0.28.0 // indirect
 )
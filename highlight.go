@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+
+	"github.com/alecthomas/chroma/v2"
+	"github.com/alecthomas/chroma/v2/formatters"
+	"github.com/alecthomas/chroma/v2/lexers"
+	"github.com/alecthomas/chroma/v2/styles"
+)
+
+const (
+	HighlightFormatter = "terminal256"
+	HighlightStyle     = "monokai"
+)
+
+// HighlightANSI colors code with ANSI escape sequences for terminals. The
+// language is taken from lang or the file name, and guessed from the code
+// itself when neither is known.
+func HighlightANSI(code []byte, lang, name string) ([]byte, error) {
+	var lexer chroma.Lexer
+	if lang != "" {
+		lexer = lexers.Get(lang)
+	}
+	if lexer == nil && name != "" {
+		lexer = lexers.Match(name)
+	}
+	if lexer == nil {
+		lexer = lexers.Analyse(string(code))
+	}
+	if lexer == nil {
+		lexer = lexers.Fallback
+	}
+	lexer = chroma.Coalesce(lexer)
+
+	iterator, err := lexer.Tokenise(nil, string(code))
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	err = formatters.Get(HighlightFormatter).Format(&buf, styles.Get(HighlightStyle), iterator)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -1,35 +1,75 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"html/template"
 	"io"
+	"mime"
+	"net"
 	"net/http"
+	"net/netip"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	TunnelHeartbeatInterval = 15 * time.Second
+	// PasswordHeader carries the password of a protected snippet for raw
+	// access and uploads, keeping it out of URLs and access logs.
+	PasswordHeader = "X-Codesnap-Password"
 )
 
 type HTTPServer struct {
 	logger              Logger
 	store               Store
+	snippets            *SnippetManager
+	rateLimiter         *RateLimiter
 	tunnelManager       *TunnelManager
 	chatCrawlerDetector *ChatCrawlerDetector
+	host                string
+	// trustedProxies are the addresses X-Real-IP is taken from, requests
+	// from anywhere else could set it to dodge the rate limits.
+	trustedProxies []netip.Prefix
 }
 
 func NewHTTPServer(
 	logger Logger,
 	store Store,
+	snippets *SnippetManager,
+	rateLimiter *RateLimiter,
 	tunnelManager *TunnelManager,
 	chatCrawlerDetector *ChatCrawlerDetector,
+	host string,
+	trustedProxies []netip.Prefix,
 ) *HTTPServer {
 	return &HTTPServer{
 		logger:              logger,
 		store:               store,
+		snippets:            snippets,
+		rateLimiter:         rateLimiter,
 		tunnelManager:       tunnelManager,
 		chatCrawlerDetector: chatCrawlerDetector,
+		host:                host,
+		trustedProxies:      trustedProxies,
 	}
 }
 
 func (h *HTTPServer) handleHomePage(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost && r.URL.Path == "/" {
+		h.handleUpload(w, r)
+		return
+	}
 	if r.Method != http.MethodGet {
 		w.WriteHeader(http.StatusMethodNotAllowed)
 		w.Write([]byte("405 - Method Not Allowed"))
@@ -61,42 +101,807 @@ func (h *HTTPServer) handleHomePage(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// handleUpload creates a snippet from a raw request body or from the file of
+// a multipart form. The query parameters work like their ssh counterparts.
+func (h *HTTPServer) handleUpload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write([]byte("405 - Method Not Allowed"))
+		return
+	}
+
+	ip := h.clientIP(r)
+	rateLimited, err := h.rateLimiter.IsRateLimited(r.Context(), ip)
+	if err != nil {
+		h.logger.Errorw("failed to check rate limit", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Something bad happened!"))
+		return
+	}
+	if rateLimited {
+		h.logger.Infow("rate limited", "ip", ip)
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte("429 - Too Many Requests"))
+		return
+	}
+
+	opts, err := uploadOptions(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("400 - %s", err)))
+		return
+	}
+	ttl := MaxTTL
+	if opts.TTL != 0 {
+		ttl = ClampTTL(opts.TTL)
+	}
+
+	body, filename, err := uploadBody(r)
+	if err != nil {
+		h.logger.Infow("invalid upload", "error", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 - Bad Request"))
+		return
+	}
+	upload, files, err := h.snippets.ReadSnippetUpload(body, opts)
+	var tooLargeErr *UploadTooLargeError
+	switch {
+	case errors.As(err, &tooLargeErr):
+		h.logger.Infow("upload too large", "received", tooLargeErr.Received)
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		w.Write([]byte(fmt.Sprintf("413 - Upload too large: %s", tooLargeErr)))
+		return
+	case err != nil && opts.Files:
+		h.logger.Infow("invalid archive", "error", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("400 - Upload rejected: %s", err)))
+		return
+	case err != nil:
+		h.logger.Errorw("failed to read upload", "error", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 - Bad Request"))
+		return
+	}
+	code := upload.Code
+	if len(code) == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 - Empty upload"))
+		return
+	}
+
+	if opts.Name == "" && filename != "" {
+		opts.Name, _ = ParseName(filename)
+	}
+
+	if !opts.Encrypt {
+		h.logger.Debugw("writing to store", "code", string(code))
+	}
+	key, secret, err := h.snippets.Create(r.Context(), code, SnippetOptions{
+		TTL:      ttl,
+		Password: opts.Password,
+		Encrypt:  opts.Encrypt,
+		Files:    files,
+		SnippetMeta: SnippetMeta{
+			Lang:      opts.Lang,
+			Name:      opts.Name,
+			Truncated: upload.Truncated,
+			Received:  upload.Received,
+			Burn:      opts.Burn,
+			Views:     opts.Views,
+		},
+	})
+	if err != nil {
+		h.logger.Errorw("failed to create snippet", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Something bad happened!"))
+		return
+	}
+	h.logger.Debugw("wrote bytes", "key", key, "bytes", len(code), "files", len(files))
+
+	link := SnippetLink(h.host, key, secret)
+	if upload.Truncated {
+		w.Header().Set("X-Codesnap-Truncated", fmt.Sprintf("received %s, stored the first %s",
+			FormatReceived(upload.Received), FormatSize(MaxUploadSize)))
+	}
+	if !strings.Contains(r.Header.Get("Accept"), "application/json") {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(link + "\n"))
+		return
+	}
+
+	type uploadResponse struct {
+		Key       string `json:"key"`
+		URL       string `json:"url"`
+		StatusURL string `json:"statusUrl"`
+		TTL       int    `json:"ttl"`
+		Truncated bool   `json:"truncated"`
+		Received  int64  `json:"received"`
+		Burn      bool   `json:"burn"`
+		Views     int    `json:"views"`
+		Protected bool   `json:"protected"`
+		Encrypted bool   `json:"encrypted"`
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	err = json.NewEncoder(w).Encode(uploadResponse{
+		Key:       key,
+		URL:       link,
+		StatusURL: fmt.Sprintf("%s/s/%s", h.host, key),
+		TTL:       int(ttl.Seconds()),
+		Truncated: upload.Truncated,
+		Received:  upload.Received,
+		Burn:      opts.Burn,
+		Views:     opts.Views,
+		Protected: opts.Password != "",
+		Encrypted: opts.Encrypt,
+	})
+	if err != nil {
+		h.logger.Errorw("failed to encode upload response", "error", err)
+		return
+	}
+}
+
+type codePage struct {
+	Key       string
+	Code      string
+	Lang      string
+	Name      string
+	Truncated bool
+	Received  string
+	Limit     string
+	Burn      bool
+	Views     int
+	ViewsLeft int
+	Protected bool
+	// Sealed is the base64 encoded code of an encrypted snippet, which is
+	// decrypted in the browser with the secret from the link fragment.
+	Sealed string
+	// Live pages reload when the snippet is updated, the rest only say so.
+	Live bool
+	// Revision is the revision shown and Latest the latest one, they are
+	// the same on the page of the snippet itself.
+	Revision int
+	Latest   int
+	// Chunks hold the code of snippets that were appended to, each shown
+	// below the time it was added.
+	Chunks []codeChunk
+	// Files hold the code of multi-file snippets.
+	Files []codeFile
+}
+
+type codeFile struct {
+	Path string
+	Lang string
+	Code string
+}
+
+type codeChunk struct {
+	Code string
+	At   string
+}
+
+// Previous is the revision before the one shown.
+func (p codePage) Previous() int {
+	return p.Revision - 1
+}
+
+func newCodePage(snippet *Snippet) codePage {
+	page := codePage{
+		Key:       snippet.Key,
+		Code:      string(snippet.Code),
+		Lang:      snippet.Lang,
+		Name:      snippet.Name,
+		Truncated: snippet.Truncated,
+		Received:  FormatReceived(snippet.Received),
+		Limit:     FormatSize(MaxUploadSize),
+		Burn:      snippet.Burn,
+		Views:     snippet.Views,
+		ViewsLeft: snippet.ViewsLeft,
+		Protected: snippet.Protected(),
+		Live:      !snippet.Protected(),
+		Revision:  max(snippet.Revision, 1),
+		Latest:    max(snippet.Revision, 1),
+	}
+	if snippet.Encrypted {
+		page.Code = ""
+		page.Sealed = base64.StdEncoding.EncodeToString(snippet.Code)
+	}
+	for i, code := range snippet.SplitFiles() {
+		page.Files = append(page.Files, codeFile{
+			Path: snippet.Files[i].Path,
+			Lang: fileLang(snippet.Files[i].Path),
+			Code: string(code),
+		})
+	}
+	if len(snippet.Chunks) > 0 {
+		for i, code := range snippet.SplitChunks() {
+			page.Chunks = append(page.Chunks, codeChunk{
+				Code: string(code),
+				At:   snippet.Chunks[i].At.UTC().Format("2006-01-02 15:04:05 UTC"),
+			})
+		}
+	}
+	return page
+}
+
+// handleCodePage shows a snippet. Password protected snippets get a prompt
+// that posts the password back to the same page. Below the key are its
+// update events, its revisions and the diffs between them.
 func (h *HTTPServer) handleCodePage(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
+	key, rest, _ := strings.Cut(r.URL.Path[3:], "/")
+	switch {
+	case rest == "" && strings.HasSuffix(key, ".zip"):
+		h.serveArchive(w, r, strings.TrimSuffix(key, ".zip"), ".zip")
+		return
+	case rest == "" && strings.HasSuffix(key, ".tar.gz"):
+		h.serveArchive(w, r, strings.TrimSuffix(key, ".tar.gz"), ".tar.gz")
+		return
+	case rest == "events":
+		h.handleSnippetEvents(w, r, key)
+		return
+	case strings.HasPrefix(rest, "v/"), strings.HasPrefix(rest, "diff/"):
+	case rest != "":
+		// the files of a multi-file snippet, ParseFilePath keeps them off the paths above
+		h.serveRaw(w, r, key, rest)
+		return
+	}
+
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
 		w.WriteHeader(http.StatusMethodNotAllowed)
 		w.Write([]byte("405 - Method Not Allowed"))
 		return
 	}
-	key := r.URL.Path[3:]
-	code, err := h.store.Get(r.Context(), key)
+	if rest != "" {
+		h.handleRevision(w, r, key, rest)
+		return
+	}
+
+	// link unfurling must not use up the views of a snippet with a view limit
+	if h.chatCrawlerDetector.IsChatCrawler(r.Header.Get("User-Agent")) {
+		meta, err := h.snippets.Meta(r.Context(), key)
+		switch {
+		case errors.Is(err, ErrKeyNotFound):
+			h.logger.Infow("key not found", "key", key)
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("404 - Not Found"))
+			return
+		case err != nil:
+			h.logger.Errorw("failed to get snippet meta", "error", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("500 - Something bad happened!"))
+			return
+		case meta.Burn:
+			h.renderMessage(w, http.StatusOK, "🔥 Burn after reading", "This snippet can only be viewed once. Open the link to read it.")
+			return
+		case meta.Views > 0:
+			h.renderMessage(w, http.StatusOK, "👀 Limited views", fmt.Sprintf("This snippet can only be viewed %d times. Open the link to read it.", meta.Views))
+			return
+		}
+	}
+
+	password := ""
+	if r.Method == http.MethodPost {
+		password = r.PostFormValue("password")
+		if password != "" && h.passwordRateLimited(w, r) {
+			return
+		}
+	}
+
+	snippet, err := h.snippets.View(r.Context(), key, password)
 	switch {
 	case errors.Is(err, ErrKeyNotFound):
 		h.logger.Infow("key not found", "key", key)
 		w.WriteHeader(http.StatusNotFound)
 		w.Write([]byte("404 - Not Found"))
 		return
+	case errors.Is(err, ErrPasswordRequired):
+		h.renderPasswordPrompt(w, http.StatusOK, r.URL.Path, "")
+		return
+	case errors.Is(err, ErrWrongPassword):
+		h.countWrongPassword(r, key)
+		h.renderPasswordPrompt(w, http.StatusUnauthorized, r.URL.Path, "Wrong password, try again.")
+		return
+	case errors.Is(err, ErrSnippetBurned):
+		h.logger.Infow("snippet already burned", "key", key)
+		h.renderMessage(w, http.StatusGone, "🔥 Burned", "This snippet was deleted after it was viewed for the first time.")
+		return
+	case errors.Is(err, ErrViewLimitReached):
+		h.logger.Infow("snippet view limit reached", "key", key)
+		h.renderMessage(w, http.StatusGone, "👀 View limit reached", "This snippet has reached its view limit and is no longer available.")
+		return
 	case err != nil:
 		h.logger.Errorw("failed to get key from redis", "error", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte("500 - Something bad happened!"))
 		return
 	}
-	h.logger.Debugw("fetched code from store", "key", key, "code", string(code))
+	h.logger.Debugw("fetched code from store", "key", key, "code", string(snippet.Code))
+
+	if snippet.Limited() || snippet.Protected() {
+		w.Header().Set("Cache-Control", "no-store")
+	}
+	h.renderTemplate(w, "code.html", newCodePage(snippet))
+}
+
+// fileLang guesses the language of a file for highlight.js from its
+// extension, which it knows most of as aliases.
+func fileLang(filePath string) string {
+	lang := strings.ToLower(strings.TrimPrefix(path.Ext(filePath), "."))
+	if !langRegexp.MatchString(lang) {
+		return ""
+	}
+	return lang
+}
+
+type diffPage struct {
+	Key  string
+	Name string
+	From int
+	To   int
+	// Header holds the file names of the unified diff, as in `--- a`.
+	Header []string
+	Hunks  []DiffHunk
+}
+
+// handleRevision shows a previous revision of a snippet under v/{n}, or the
+// changes between two revisions under diff/{a}..{b}.
+func (h *HTTPServer) handleRevision(w http.ResponseWriter, r *http.Request, key, rest string) {
+	var revisions []int
+	if n, ok := strings.CutPrefix(rest, "v/"); ok {
+		revisions = parseRevisions(n)
+	} else {
+		from, to, ok := strings.Cut(strings.TrimPrefix(rest, "diff/"), "..")
+		if ok {
+			revisions = parseRevisions(from, to)
+		}
+	}
+	if revisions == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("404 - Not Found"))
+		return
+	}
+
+	password := ""
+	if r.Method == http.MethodPost {
+		password = r.PostFormValue("password")
+		if password != "" && h.passwordRateLimited(w, r) {
+			return
+		}
+	}
+
+	snippets, latest, err := h.snippets.Revisions(r.Context(), key, password, revisions...)
+	switch {
+	case errors.Is(err, ErrKeyNotFound), errors.Is(err, ErrRevisionNotFound):
+		h.logger.Infow("revision not found", "key", key, "revisions", revisions)
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("404 - Not Found"))
+		return
+	case errors.Is(err, ErrPasswordRequired):
+		h.renderPasswordPrompt(w, http.StatusOK, r.URL.Path, "")
+		return
+	case errors.Is(err, ErrWrongPassword):
+		h.countWrongPassword(r, key)
+		h.renderPasswordPrompt(w, http.StatusUnauthorized, r.URL.Path, "Wrong password, try again.")
+		return
+	case errors.Is(err, ErrSnippetBurned):
+		h.renderMessage(w, http.StatusGone, "🔥 Burned", "This snippet was deleted after it was viewed for the first time.")
+		return
+	case err != nil:
+		h.logger.Errorw("failed to get revisions from store", "key", key, "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Something bad happened!"))
+		return
+	}
+	if snippets[0].Protected() {
+		w.Header().Set("Cache-Control", "no-store")
+	}
+
+	if len(snippets) == 1 {
+		page := newCodePage(snippets[0])
+		page.Latest = latest
+		page.Live = page.Live && page.Revision == latest
+		h.renderTemplate(w, "code.html", page)
+		return
+	}
+
+	from, to := snippets[0], snippets[1]
+	name := cmp.Or(to.Name, key)
+	h.renderTemplate(w, "diff.html", diffPage{
+		Key:  key,
+		Name: name,
+		From: revisions[0],
+		To:   revisions[1],
+		Header: []string{
+			fmt.Sprintf("--- a/%s (revision %d)", cmp.Or(from.Name, key), revisions[0]),
+			fmt.Sprintf("+++ b/%s (revision %d)", name, revisions[1]),
+		},
+		Hunks: Diff(string(from.JoinedCode()), string(to.JoinedCode())),
+	})
+}
+
+// parseRevisions parses revision numbers, it returns nil unless all of
+// them are positive numbers.
+func parseRevisions(values ...string) []int {
+	revisions := make([]int, 0, len(values))
+	for _, value := range values {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			return nil
+		}
+		revisions = append(revisions, n)
+	}
+	return revisions
+}
+
+func (h *HTTPServer) renderTemplate(w http.ResponseWriter, name string, data any) {
+	t, err := template.New(name).ParseFiles("./templates/" + name)
+	if err != nil {
+		h.logger.Errorw("failed to parse template", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Something bad happened!"))
+		return
+	}
+	err = t.Execute(w, data)
+	if err != nil {
+		h.logger.Errorw("failed to execute template", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Something bad happened!"))
+		return
+	}
+}
+
+// handleSnippetEvents sends an updated event to the page of a snippet every
+// time its owner updates it, as server-sent events.
+func (h *HTTPServer) handleSnippetEvents(w http.ResponseWriter, r *http.Request, key string) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write([]byte("405 - Method Not Allowed"))
+		return
+	}
+	meta, err := h.snippets.Meta(r.Context(), key)
+	switch {
+	case errors.Is(err, ErrKeyNotFound):
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("404 - Not Found"))
+		return
+	case err != nil:
+		h.logger.Errorw("failed to get key from store", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Something bad happened!"))
+		return
+	}
+	// limited snippets are gone once viewed and encrypted ones can't be updated
+	if meta.Limited() || meta.Encrypted {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	rc := http.NewResponseController(w)
+
+	changed, stop := h.snippets.Watch(key)
+	defer func() { stop() }()
+	// the headers tell the viewer it is watching
+	if err := rc.Flush(); err != nil {
+		h.logger.Errorw("failed to flush snippet events", "key", key, "error", err)
+		return
+	}
+
+	heartbeat := time.NewTicker(TunnelHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		var event string
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			event = ": heartbeat\n\n"
+		case <-changed:
+			nextChanged, nextStop := h.snippets.Watch(key)
+			stop()
+			changed, stop = nextChanged, nextStop
+			h.logger.Debugw("notifying viewer of update", "key", key)
+			event = "event: updated\ndata: \n\n"
+		}
+
+		if _, err := io.WriteString(w, event); err != nil {
+			h.logger.Debugw("failed to write snippet event", "key", key, "error", err)
+			return
+		}
+		if err := rc.Flush(); err != nil {
+			h.logger.Errorw("failed to flush snippet event", "key", key, "error", err)
+			return
+		}
+	}
+}
+
+type messagePage struct {
+	Title   string
+	Message string
+}
+
+// renderMessage shows a short explanation in place of a snippet.
+func (h *HTTPServer) renderMessage(w http.ResponseWriter, status int, title, message string) {
+	t, err := template.New("message.html").ParseFiles("./templates/message.html")
+	if err != nil {
+		h.logger.Errorw("failed to parse template", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Something bad happened!"))
+		return
+	}
+	w.WriteHeader(status)
+	err = t.Execute(w, messagePage{Title: title, Message: message})
+	if err != nil {
+		h.logger.Errorw("failed to execute template", "error", err)
+		return
+	}
+}
 
-	t, err := template.New("code.html").ParseFiles("./templates/code.html")
+type passwordPage struct {
+	// Action is the page the password is posted back to.
+	Action    string
+	Error     string
+	MaxLength int
+}
+
+func (h *HTTPServer) renderPasswordPrompt(w http.ResponseWriter, status int, action, message string) {
+	t, err := template.New("password.html").ParseFiles("./templates/password.html")
 	if err != nil {
 		h.logger.Errorw("failed to parse template", "error", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte("500 - Something bad happened!"))
 		return
 	}
-	err = t.Execute(w, string(code))
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	err = t.Execute(w, passwordPage{Action: action, Error: message, MaxLength: MaxPasswordLength})
 	if err != nil {
 		h.logger.Errorw("failed to execute template", "error", err)
+		return
+	}
+}
+
+// passwordRateLimited answers with 429 once the client has used up its
+// attempts, so passwords can't be brute forced. Only wrong passwords are
+// counted, see countWrongPassword.
+func (h *HTTPServer) passwordRateLimited(w http.ResponseWriter, r *http.Request) bool {
+	ip := h.clientIP(r)
+	limited, err := h.rateLimiter.Reached(r.Context(), ip)
+	if err != nil {
+		h.logger.Errorw("failed to check rate limit", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Something bad happened!"))
+		return true
+	}
+	if limited {
+		h.logger.Infow("rate limited", "ip", ip)
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte("429 - Too Many Requests"))
+		return true
+	}
+	return false
+}
+
+func (h *HTTPServer) countWrongPassword(r *http.Request, key string) {
+	ip := h.clientIP(r)
+	h.logger.Infow("wrong password", "key", key, "ip", ip)
+	err := h.rateLimiter.Count(r.Context(), ip)
+	if err != nil {
+		h.logger.Errorw("failed to count wrong password", "error", err)
+	}
+}
+
+// handleSnippetStatus lets the uploader check on a snippet, e.g. whether a
+// burn after reading snippet has been read or how many views are left,
+// without viewing it.
+func (h *HTTPServer) handleSnippetStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write([]byte("405 - Method Not Allowed"))
+		return
+	}
+	key := r.URL.Path[3:]
+	status, err := h.snippets.Status(r.Context(), key)
+	switch {
+	case errors.Is(err, ErrKeyNotFound):
+		h.logger.Infow("key not found", "key", key)
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("404 - Not Found"))
+		return
+	case err != nil:
+		h.logger.Errorw("failed to get snippet status", "error", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte("500 - Something bad happened!"))
 		return
 	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	err = json.NewEncoder(w).Encode(status)
+	if err != nil {
+		h.logger.Errorw("failed to encode snippet status", "error", err)
+		return
+	}
+}
+
+// handleRawCode serves the stored snippet as plain text. With the download
+// query parameter it is served as an attachment named after its value.
+// Password protected snippets need the password in the PasswordHeader.
+// Encrypted snippets are served sealed, as they are stored.
+func (h *HTTPServer) handleRawCode(w http.ResponseWriter, r *http.Request) {
+	h.serveRaw(w, r, r.URL.Path[3:], "")
+}
+
+// serveRaw writes the code of a snippet as plain text, or only the file at
+// filePath of a multi-file snippet.
+func (h *HTTPServer) serveRaw(w http.ResponseWriter, r *http.Request, key, filePath string) {
+	var check func(record *SnippetRecord) error
+	if filePath != "" {
+		check = func(record *SnippetRecord) error {
+			if _, ok := record.File(filePath); !ok {
+				return ErrFileNotFound
+			}
+			return nil
+		}
+	}
+	snippet, ok := h.viewRaw(w, r, key, check)
+	if !ok {
+		return
+	}
+
+	code := snippet.JoinedCode()
+	name := snippet.Name
+	if filePath != "" {
+		code, _ = snippet.File(filePath)
+		name = path.Base(filePath)
+	}
+
+	sum := sha1.Sum(code)
+	if snippet.Limited() || snippet.Protected() {
+		w.Header().Set("Cache-Control", "no-store")
+	}
+	if snippet.Encrypted {
+		// only the sealed snippet is stored: the nonce followed by the ciphertext
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("X-Codesnap-Encrypted", "aes-256-gcm")
+	} else {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	w.Header().Set("ETag", fmt.Sprintf("\"%s\"", hex.EncodeToString(sum[:])))
+	if r.URL.Query().Has("download") {
+		filename := r.URL.Query().Get("download")
+		if filename == "" {
+			filename = name
+		}
+		w.Header().Set("Content-Disposition", contentDisposition(filename, key))
+	}
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(code))
+}
+
+// serveArchive streams the files of a multi-file snippet as a zip or
+// gzipped tar archive, which is picked by the extension of the link.
+func (h *HTTPServer) serveArchive(w http.ResponseWriter, r *http.Request, key, ext string) {
+	// snippets of a single file have no archive, asking for one isn't a view
+	snippet, ok := h.viewRaw(w, r, key, func(record *SnippetRecord) error {
+		if len(record.Files) == 0 {
+			return ErrFileNotFound
+		}
+		return nil
+	})
+	if !ok {
+		return
+	}
+
+	if snippet.Limited() || snippet.Protected() {
+		w.Header().Set("Cache-Control", "no-store")
+	}
+	w.Header().Set("Content-Disposition", contentDisposition(key+ext, key))
+	write := snippet.WriteZip
+	w.Header().Set("Content-Type", "application/zip")
+	if ext == ".tar.gz" {
+		write = snippet.WriteTarGz
+		w.Header().Set("Content-Type", "application/gzip")
+	}
+	if r.Method == http.MethodHead {
+		return
+	}
+	err := write(w)
+	if err != nil {
+		h.logger.Errorw("failed to write archive", "error", err, "key", key)
+	}
+}
+
+// viewRaw fetches a snippet for raw access, counting it as a view unless
+// check fails, see ViewChecked. The password of protected snippets comes
+// from the PasswordHeader. It writes the error response and returns false
+// when the snippet can't be read.
+func (h *HTTPServer) viewRaw(w http.ResponseWriter, r *http.Request, key string, check func(record *SnippetRecord) error) (*Snippet, bool) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write([]byte("405 - Method Not Allowed"))
+		return nil, false
+	}
+
+	// neither link unfurling nor HEAD requests count as a view of a snippet with a view limit
+	if r.Method == http.MethodHead || h.chatCrawlerDetector.IsChatCrawler(r.Header.Get("User-Agent")) {
+		meta, err := h.snippets.Meta(r.Context(), key)
+		if err == nil && meta.Limited() {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("403 - Forbidden"))
+			return nil, false
+		}
+	}
+
+	password := r.Header.Get(PasswordHeader)
+	if password != "" && h.passwordRateLimited(w, r) {
+		return nil, false
+	}
+
+	snippet, err := h.snippets.ViewChecked(r.Context(), key, password, check)
+	switch {
+	case errors.Is(err, ErrKeyNotFound):
+		h.logger.Infow("key not found", "key", key)
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("404 - Not Found"))
+		return nil, false
+	case errors.Is(err, ErrFileNotFound):
+		h.logger.Infow("file not found", "key", key)
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("404 - Not Found"))
+		return nil, false
+	case errors.Is(err, ErrPasswordRequired):
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(fmt.Sprintf("401 - Password required, send it in the %s header", PasswordHeader)))
+		return nil, false
+	case errors.Is(err, ErrWrongPassword):
+		h.countWrongPassword(r, key)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("401 - Wrong password"))
+		return nil, false
+	case errors.Is(err, ErrSnippetBurned):
+		h.logger.Infow("snippet already burned", "key", key)
+		w.WriteHeader(http.StatusGone)
+		w.Write([]byte("410 - Gone"))
+		return nil, false
+	case errors.Is(err, ErrViewLimitReached):
+		h.logger.Infow("snippet view limit reached", "key", key)
+		w.WriteHeader(http.StatusGone)
+		w.Write([]byte("410 - Gone"))
+		return nil, false
+	case err != nil:
+		h.logger.Errorw("failed to get key from store", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Something bad happened!"))
+		return nil, false
+	}
+
+	return snippet, true
+}
+
+// contentDisposition builds an attachment header, falling back on the
+// snippet key when no usable filename was given.
+func contentDisposition(filename, key string) string {
+	filename = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f || r == '/' || r == '\\' {
+			return -1
+		}
+		return r
+	}, filename)
+	filename = strings.TrimSpace(filename)
+	if filename == "" || filename == "." || filename == ".." {
+		filename = key + ".txt"
+	}
+
+	disposition := mime.FormatMediaType("attachment", map[string]string{"filename": filename})
+	if disposition == "" {
+		return mime.FormatMediaType("attachment", map[string]string{"filename": key + ".txt"})
+	}
+	return disposition
 }
 
 func (h *HTTPServer) handleTunnel(w http.ResponseWriter, r *http.Request) {
@@ -111,7 +916,7 @@ func (h *HTTPServer) handleTunnel(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	key := r.URL.Path[3:]
+	key, events := strings.CutSuffix(r.URL.Path[3:], "/events")
 	tunnel := h.tunnelManager.GetTunnel(key)
 	if tunnel == nil {
 		h.logger.Infow("key not found", "key", key)
@@ -119,14 +924,49 @@ func (h *HTTPServer) handleTunnel(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte("404 - Not Found"))
 		return
 	}
-	defer tunnel.Done()
 	h.logger.Debugw("fetched tunnel from store", "key", key)
 
-	n, err := io.Copy(w, tunnel)
+	switch {
+	case events:
+		h.handleTunnelEvents(w, r, key, tunnel)
+	case strings.Contains(r.Header.Get("Accept"), "text/html"):
+		h.handleTunnelPage(w, key)
+	default:
+		h.handleTunnelStream(w, r, key, tunnel)
+	}
+}
+
+func (h *HTTPServer) handleTunnelPage(w http.ResponseWriter, key string) {
+	t, err := template.New("tunnel.html").ParseFiles("./templates/tunnel.html")
+	if err != nil {
+		h.logger.Errorw("failed to parse template", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Something bad happened!"))
+		return
+	}
+	err = t.Execute(w, key)
+	if err != nil {
+		h.logger.Errorw("failed to execute template", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Something bad happened!"))
+		return
+	}
+}
+
+// handleTunnelStream writes the raw stream, flushing every chunk so that
+// curl and friends see the data as soon as it arrives.
+func (h *HTTPServer) handleTunnelStream(w http.ResponseWriter, r *http.Request, key string, tunnel *TunnelData) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Accel-Buffering", "no")
+
+	n, err := io.Copy(&flushWriter{w: w, rc: http.NewResponseController(w)}, tunnel.NewReader(r.Context(), 0))
 	switch {
 	case errors.Is(err, ErrStreamSizeExceeded):
 		h.logger.Warnw("stream size exceeded", "key", key)
 		return
+	case errors.Is(err, context.Canceled):
+		h.logger.Debugw("viewer disconnected from tunnel", "key", key, "bytes", n)
+		return
 	case err != nil:
 		h.logger.Errorw("failed to copy from tunnel to http response", "error", err)
 		return
@@ -135,6 +975,86 @@ func (h *HTTPServer) handleTunnel(w http.ResponseWriter, r *http.Request) {
 	h.logger.Debugw("copied bytes from tunnel to http response", "key", key, "bytes", n)
 }
 
+// handleTunnelEvents streams the tunnel line by line as server-sent events.
+// Every event carries the stream offset after its line as id, so a
+// reconnecting EventSource resumes where it left off via Last-Event-ID.
+func (h *HTTPServer) handleTunnelEvents(w http.ResponseWriter, r *http.Request, key string, tunnel *TunnelData) {
+	offset, err := strconv.Atoi(r.Header.Get("Last-Event-ID"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	rc := http.NewResponseController(w)
+
+	type tunnelLine struct {
+		text string
+		err  error
+	}
+	lines := make(chan tunnelLine)
+	go func() {
+		defer close(lines)
+		reader := bufio.NewReader(tunnel.NewReader(r.Context(), offset))
+		for {
+			text, err := reader.ReadString('\n')
+			select {
+			case lines <- tunnelLine{text: text, err: err}:
+			case <-r.Context().Done():
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	heartbeat := time.NewTicker(TunnelHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		var event string
+		select {
+		case <-heartbeat.C:
+			event = ": heartbeat\n\n"
+		case line, ok := <-lines:
+			if !ok {
+				return
+			}
+			if line.text != "" {
+				offset += len(line.text)
+				text := strings.TrimRight(line.text, "\r\n")
+				text = strings.ReplaceAll(text, "\r", "")
+				event = fmt.Sprintf("id: %d\ndata: %s\n\n", offset, text)
+			}
+			switch {
+			case line.err == nil:
+			case errors.Is(line.err, io.EOF):
+				event += "event: done\ndata: \n\n"
+			case errors.Is(line.err, ErrStreamSizeExceeded):
+				h.logger.Warnw("stream size exceeded", "key", key)
+				event += "event: failed\ndata: stream size exceeded\n\n"
+			case errors.Is(line.err, context.Canceled):
+				h.logger.Debugw("viewer disconnected from tunnel", "key", key, "offset", offset)
+				return
+			default:
+				h.logger.Errorw("failed to read from tunnel", "key", key, "error", line.err)
+				event += "event: failed\ndata: stream interrupted\n\n"
+			}
+		}
+
+		if _, err := io.WriteString(w, event); err != nil {
+			h.logger.Debugw("failed to write tunnel event", "key", key, "error", err)
+			return
+		}
+		if err := rc.Flush(); err != nil {
+			h.logger.Errorw("failed to flush tunnel event", "key", key, "error", err)
+			return
+		}
+	}
+}
+
 func (h *HTTPServer) getTunnelCount(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		w.WriteHeader(http.StatusMethodNotAllowed)
@@ -159,15 +1079,135 @@ func (h *HTTPServer) getTunnelCount(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func (h *HTTPServer) ListenAndServe(addr string) error {
+// uploadOptions reads the upload options from the query string, validated
+// by the same rules as the ssh command line. The password may also be sent
+// in the PasswordHeader.
+func uploadOptions(r *http.Request) (Options, error) {
+	var args []string
+	if password := r.Header.Get(PasswordHeader); password != "" && !r.URL.Query().Has(CmdPassword.String()) {
+		args = append(args, fmt.Sprintf("%s=%s", CmdPassword, password))
+	}
+	for _, cmd := range UploadCommands {
+		if !r.URL.Query().Has(cmd.String()) {
+			continue
+		}
+		value := r.URL.Query().Get(cmd.String())
+		if cmd.IsFlag() && value == "" {
+			args = append(args, cmd.String())
+			continue
+		}
+		args = append(args, fmt.Sprintf("%s=%s", cmd, value))
+	}
+	return ParseOptions(args)
+}
+
+// uploadBody returns the file part and its file name of a multipart upload,
+// or the request body itself for any other content type.
+func uploadBody(r *http.Request) (io.Reader, string, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		return r.Body, "", nil
+	}
+
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, "", err
+	}
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return nil, "", errors.New("multipart upload without a file")
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		if part.FormName() == "file" || part.FileName() != "" {
+			return part, part.FileName(), nil
+		}
+	}
+}
+
+// clientIP prefers the address set by the nginx reverse proxy, when the
+// request comes from one of the trusted proxies.
+func (h *HTTPServer) clientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	if realIP := r.Header.Get("X-Real-IP"); realIP != "" && h.isTrustedProxy(ip) {
+		return realIP
+	}
+	return ip
+}
+
+func (h *HTTPServer) isTrustedProxy(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	for _, prefix := range h.trustedProxies {
+		if prefix.Contains(addr.Unmap()) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseTrustedProxies parses a comma separated list of addresses and CIDR
+// ranges, e.g. `127.0.0.1,172.16.0.0/12`.
+func ParseTrustedProxies(value string) ([]netip.Prefix, error) {
+	var proxies []netip.Prefix
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		if !strings.Contains(field, "/") {
+			addr, err := netip.ParseAddr(field)
+			if err != nil {
+				return nil, err
+			}
+			proxies = append(proxies, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(field)
+		if err != nil {
+			return nil, err
+		}
+		proxies = append(proxies, prefix.Masked())
+	}
+	return proxies, nil
+}
+
+type flushWriter struct {
+	w  io.Writer
+	rc *http.ResponseController
+}
+
+func (f *flushWriter) Write(p []byte) (int, error) {
+	n, err := f.w.Write(p)
+	if err != nil {
+		return n, err
+	}
+	return n, f.rc.Flush()
+}
+
+func (h *HTTPServer) Handler() http.Handler {
 	mux := http.NewServeMux()
 
 	mux.HandleFunc("/", h.handleHomePage)
 	mux.HandleFunc("/c/", h.handleCodePage)
+	mux.HandleFunc("/r/", h.handleRawCode)
+	mux.HandleFunc("/s/", h.handleSnippetStatus)
 	mux.HandleFunc("/t/", h.handleTunnel)
 	mux.HandleFunc("/tunnels", h.getTunnelCount)
+	mux.HandleFunc("/api/snippets", h.handleUpload)
 
 	fs := http.FileServer(http.Dir("./static"))
 	mux.Handle("/static/", http.StripPrefix("/static/", fs))
-	return http.ListenAndServe(addr, mux)
+	return mux
+}
+
+func (h *HTTPServer) ListenAndServe(addr string) error {
+	return http.ListenAndServe(addr, h.Handler())
 }
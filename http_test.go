@@ -0,0 +1,929 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func newTestHTTPServer(t *testing.T) (*HTTPServer, Store) {
+	t.Helper()
+	store := NewMemoryStore()
+	return NewHTTPServer(
+		zap.NewNop().Sugar(),
+		store,
+		NewSnippetManager(store, false, DefaultMaxRevisions),
+		NewRateLimiter(store),
+		NewTunnelManager(),
+		NewChatCrawlerDetector(),
+		"http://localhost",
+		nil,
+	), store
+}
+
+func TestHTTPServer_handleCodePage(t *testing.T) {
+	h, store := newTestHTTPServer(t)
+	if err := store.Set(context.Background(), "abc1234", "fmt.Println(\"hi\")", time.Minute); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "existing snippet",
+			method:     http.MethodGet,
+			path:       "/c/abc1234",
+			wantStatus: http.StatusOK,
+			wantBody:   "fmt.Println(&#34;hi&#34;)",
+		},
+		{
+			name:       "missing snippet",
+			method:     http.MethodGet,
+			path:       "/c/nope",
+			wantStatus: http.StatusNotFound,
+			wantBody:   "404 - Not Found",
+		},
+		{
+			name:       "wrong method",
+			method:     http.MethodDelete,
+			path:       "/c/abc1234",
+			wantStatus: http.StatusMethodNotAllowed,
+			wantBody:   "405 - Method Not Allowed",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			h.Handler().ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if !strings.Contains(rec.Body.String(), tt.wantBody) {
+				t.Errorf("body does not contain %q:\n%s", tt.wantBody, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestHTTPServer_handleUpload(t *testing.T) {
+	multipartBody := &bytes.Buffer{}
+	mw := multipart.NewWriter(multipartBody)
+	fw, err := mw.CreateFormFile("file", "main.go")
+	if err != nil {
+		t.Fatalf("CreateFormFile() error = %v", err)
+	}
+	fw.Write([]byte("package main"))
+	mw.Close()
+	archive := tarFiles(t, "a.go", "package a\n", "b.go", "package b\n")
+
+	tests := []struct {
+		name        string
+		path        string
+		contentType string
+		body        io.Reader
+		wantStatus  int
+		wantCode    string
+		wantFiles   []string
+	}{
+		{
+			name:        "raw body",
+			path:        "/",
+			contentType: "text/plain",
+			body:        strings.NewReader("fmt.Println()"),
+			wantStatus:  http.StatusCreated,
+			wantCode:    "fmt.Println()",
+		},
+		{
+			name:        "multipart file",
+			path:        "/api/snippets?ttl=600",
+			contentType: mw.FormDataContentType(),
+			body:        multipartBody,
+			wantStatus:  http.StatusCreated,
+			wantCode:    "package main",
+		},
+		{
+			name:        "truncated at max upload size",
+			path:        "/api/snippets",
+			contentType: "application/octet-stream",
+			body:        strings.NewReader(strings.Repeat("a", MaxUploadSize+10)),
+			wantStatus:  http.StatusCreated,
+			wantCode:    strings.Repeat("a", MaxUploadSize),
+		},
+		{
+			name:       "empty body",
+			path:       "/api/snippets",
+			body:       strings.NewReader(""),
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "invalid ttl",
+			path:       "/api/snippets?ttl=soon",
+			body:       strings.NewReader("code"),
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "files",
+			path:       "/api/snippets?files",
+			body:       bytes.NewReader(archive),
+			wantStatus: http.StatusCreated,
+			wantCode:   "package a\npackage b\n",
+			wantFiles:  []string{"a.go", "b.go"},
+		},
+		{
+			name:       "tar archive without files",
+			path:       "/api/snippets",
+			body:       bytes.NewReader(archive),
+			wantStatus: http.StatusCreated,
+			wantCode:   "package a\npackage b\n",
+			wantFiles:  []string{"a.go", "b.go"},
+		},
+		{
+			name:       "files without an archive",
+			path:       "/api/snippets?files",
+			body:       strings.NewReader("code"),
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, store := newTestHTTPServer(t)
+			req := httptest.NewRequest(http.MethodPost, tt.path, tt.body)
+			req.Header.Set("Content-Type", tt.contentType)
+			rec := httptest.NewRecorder()
+			h.Handler().ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if tt.wantStatus != http.StatusCreated {
+				return
+			}
+
+			link := strings.TrimSpace(rec.Body.String())
+			key := strings.TrimPrefix(link, "http://localhost/c/")
+			raw, err := store.Get(context.Background(), key)
+			if err != nil {
+				t.Fatalf("Get(%q) error = %v", key, err)
+			}
+			record, err := DecodeSnippetRecord(raw)
+			if err != nil {
+				t.Fatalf("DecodeSnippetRecord() error = %v", err)
+			}
+			if string(record.Code) != tt.wantCode {
+				t.Errorf("stored %d bytes, want %d", len(record.Code), len(tt.wantCode))
+			}
+			var files []string
+			for _, file := range record.Files {
+				files = append(files, file.Path)
+			}
+			if !reflect.DeepEqual(files, tt.wantFiles) {
+				t.Errorf("stored files %q, want %q", files, tt.wantFiles)
+			}
+			count, err := store.Get(context.Background(), CodeUploadedCountKey)
+			if err != nil || string(count) != "1" {
+				t.Errorf("uploaded count = %q, %v", count, err)
+			}
+		})
+	}
+}
+
+func TestHTTPServer_handleUploadJSON(t *testing.T) {
+	h, _ := newTestHTTPServer(t)
+	req := httptest.NewRequest(http.MethodPost, "/api/snippets?ttl=1", strings.NewReader("code"))
+	req.Header.Set("Accept", "application/json")
+	rec := httptest.NewRecorder()
+	h.handleUpload(rec, req)
+
+	var res struct {
+		Key string `json:"key"`
+		URL string `json:"url"`
+		TTL int    `json:"ttl"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if res.URL != "http://localhost/c/"+res.Key || len(res.Key) != KeyLength {
+		t.Errorf("unexpected response %+v", res)
+	}
+	if res.TTL != int(MinTTL.Seconds()) {
+		t.Errorf("ttl = %d, want it clamped to %d", res.TTL, int(MinTTL.Seconds()))
+	}
+}
+
+func TestHTTPServer_handleRawCode(t *testing.T) {
+	h, store := newTestHTTPServer(t)
+	code := "#!/bin/sh\necho hi\n"
+	if err := store.Set(context.Background(), "abc1234", code, time.Minute); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	h.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/r/abc1234", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if rec.Body.String() != code {
+		t.Errorf("body = %q, want %q", rec.Body.String(), code)
+	}
+	if got := rec.Header().Get("Content-Length"); got != "18" {
+		t.Errorf("Content-Length = %q, want 18", got)
+	}
+	if got := rec.Header().Get("Content-Disposition"); got != "" {
+		t.Errorf("Content-Disposition = %q, want none", got)
+	}
+
+	etag := rec.Header().Get("ETag")
+	req := httptest.NewRequest(http.MethodGet, "/r/abc1234", nil)
+	req.Header.Set("If-None-Match", etag)
+	rec = httptest.NewRecorder()
+	h.Handler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotModified {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusNotModified)
+	}
+
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{query: "download=install.sh", want: `attachment; filename=install.sh`},
+		{query: "download=../../etc/passwd", want: `attachment; filename=....etcpasswd`},
+		{query: "download", want: `attachment; filename=abc1234.txt`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			h.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/r/abc1234?"+tt.query, nil))
+			if got := rec.Header().Get("Content-Disposition"); got != tt.want {
+				t.Errorf("Content-Disposition = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	rec = httptest.NewRecorder()
+	h.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/r/nope", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+func TestHTTPServer_truncatedUpload(t *testing.T) {
+	h, _ := newTestHTTPServer(t)
+	req := httptest.NewRequest(http.MethodPost, "/api/snippets", strings.NewReader(strings.Repeat("a", MaxUploadSize+2048)))
+	rec := httptest.NewRecorder()
+	h.Handler().ServeHTTP(rec, req)
+
+	if got := rec.Header().Get("X-Codesnap-Truncated"); got != "received 1.0 MB, stored the first 1.0 MB" {
+		t.Errorf("X-Codesnap-Truncated = %q", got)
+	}
+
+	link := strings.TrimSpace(rec.Body.String())
+	rec = httptest.NewRecorder()
+	h.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, strings.TrimPrefix(link, "http://localhost"), nil))
+	if !strings.Contains(rec.Body.String(), "This snippet was truncated") {
+		t.Errorf("code page does not show the truncation notice")
+	}
+}
+
+func TestHTTPServer_rejectedUpload(t *testing.T) {
+	store := NewMemoryStore()
+	h := NewHTTPServer(
+		zap.NewNop().Sugar(),
+		store,
+		NewSnippetManager(store, true, DefaultMaxRevisions),
+		NewRateLimiter(store),
+		NewTunnelManager(),
+		NewChatCrawlerDetector(),
+		"http://localhost",
+		nil,
+	)
+	req := httptest.NewRequest(http.MethodPost, "/api/snippets", strings.NewReader(strings.Repeat("a", MaxUploadSize+1)))
+	rec := httptest.NewRecorder()
+	h.Handler().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusRequestEntityTooLarge)
+	}
+	if _, err := store.Get(context.Background(), CodeUploadedCountKey); err == nil {
+		t.Errorf("rejected upload was counted")
+	}
+}
+
+func TestHTTPServer_burnAfterReading(t *testing.T) {
+	h, _ := newTestHTTPServer(t)
+	rec := httptest.NewRecorder()
+	h.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/snippets?burn", strings.NewReader("password=hunter2")))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusCreated)
+	}
+	path := strings.TrimPrefix(strings.TrimSpace(rec.Body.String()), "http://localhost")
+	key := strings.TrimPrefix(path, "/c/")
+
+	status := func() SnippetStatus {
+		rec := httptest.NewRecorder()
+		h.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/s/"+key, nil))
+		var status SnippetStatus
+		if err := json.NewDecoder(rec.Body).Decode(&status); err != nil {
+			t.Fatalf("Decode() error = %v", err)
+		}
+		return status
+	}
+	if s := status(); !s.Burn || s.Consumed {
+		t.Errorf("status before viewing = %+v", s)
+	}
+
+	crawler := httptest.NewRequest(http.MethodGet, path, nil)
+	crawler.Header.Set("User-Agent", "Slackbot-LinkExpanding 1.0 (+https://api.slack.com/robots)")
+	rec = httptest.NewRecorder()
+	h.Handler().ServeHTTP(rec, crawler)
+	if strings.Contains(rec.Body.String(), "hunter2") {
+		t.Errorf("crawler got the snippet")
+	}
+
+	rec = httptest.NewRecorder()
+	h.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "hunter2") {
+		t.Fatalf("first view: status = %d", rec.Code)
+	}
+	// the snippet is gone once shown, there's nothing left to fetch raw
+	if strings.Contains(rec.Body.String(), "/r/"+key) {
+		t.Errorf("first view links to the raw snippet")
+	}
+
+	for _, p := range []string{path, "/r/" + key} {
+		rec = httptest.NewRecorder()
+		h.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, p, nil))
+		if rec.Code != http.StatusGone {
+			t.Errorf("%s after burning: status = %d, want %d", p, rec.Code, http.StatusGone)
+		}
+	}
+	if s := status(); !s.Consumed || s.ConsumedAt == nil {
+		t.Errorf("status after viewing = %+v", s)
+	}
+}
+
+func TestHTTPServer_chatCrawler(t *testing.T) {
+	h, store := newTestHTTPServer(t)
+	ctx := context.Background()
+	if err := store.Set(ctx, "abc1234", "fmt.Println(\"hi\")", time.Minute); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if err := store.Set(ctx, "bad1234", "\x00csr\x09{}", time.Minute); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		path       string
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "existing snippet",
+			path:       "/c/abc1234",
+			wantStatus: http.StatusOK,
+			wantBody:   "fmt.Println(&#34;hi&#34;)",
+		},
+		{
+			name:       "missing snippet",
+			path:       "/c/nope123",
+			wantStatus: http.StatusNotFound,
+			wantBody:   "404 - Not Found",
+		},
+		{
+			name:       "unreadable snippet",
+			path:       "/c/bad1234",
+			wantStatus: http.StatusInternalServerError,
+			wantBody:   "500 - Something bad happened!",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			req.Header.Set("User-Agent", "Slackbot-LinkExpanding 1.0 (+https://api.slack.com/robots)")
+			rec := httptest.NewRecorder()
+			h.Handler().ServeHTTP(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if !strings.Contains(rec.Body.String(), tt.wantBody) {
+				t.Errorf("body does not contain %q:\n%s", tt.wantBody, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestHTTPServer_viewLimit(t *testing.T) {
+	h, _ := newTestHTTPServer(t)
+	rec := httptest.NewRecorder()
+	h.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/snippets?views=2", strings.NewReader("fmt.Println()")))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusCreated)
+	}
+	key := strings.TrimPrefix(strings.TrimSpace(rec.Body.String()), "http://localhost/c/")
+
+	paths := []string{"/c/" + key, "/r/" + key, "/c/" + key, "/r/" + key}
+	wantCodes := []int{http.StatusOK, http.StatusOK, http.StatusGone, http.StatusGone}
+	for i, path := range paths {
+		rec := httptest.NewRecorder()
+		h.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != wantCodes[i] {
+			t.Errorf("view %d of %s: status = %d, want %d", i+1, path, rec.Code, wantCodes[i])
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	h.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/s/"+key, nil))
+	var status SnippetStatus
+	if err := json.NewDecoder(rec.Body).Decode(&status); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if status.Views != 2 || status.ViewsLeft == nil || *status.ViewsLeft != 0 || !status.Consumed {
+		t.Errorf("status = %+v", status)
+	}
+}
+
+func TestHTTPServer_passwordProtected(t *testing.T) {
+	h, store := newTestHTTPServer(t)
+	rec := httptest.NewRecorder()
+	h.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/snippets?password=hunter2", strings.NewReader("fmt.Println()")))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusCreated)
+	}
+	key := strings.TrimPrefix(strings.TrimSpace(rec.Body.String()), "http://localhost/c/")
+
+	raw, err := store.Get(context.Background(), key)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if strings.Contains(string(raw), "hunter2") {
+		t.Errorf("password is stored in plaintext: %s", raw)
+	}
+
+	postPassword := func(password string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/c/"+key, strings.NewReader("password="+password))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		h.Handler().ServeHTTP(rec, req)
+		return rec
+	}
+	getRaw := func(password string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/r/"+key, nil)
+		if password != "" {
+			req.Header.Set(PasswordHeader, password)
+		}
+		rec := httptest.NewRecorder()
+		h.Handler().ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec = httptest.NewRecorder()
+	h.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/c/"+key, nil))
+	if rec.Code != http.StatusOK || strings.Contains(rec.Body.String(), "fmt.Println()") || !strings.Contains(rec.Body.String(), `name="password"`) {
+		t.Errorf("GET without password: status = %d, want the password prompt", rec.Code)
+	}
+	if rec := postPassword("hunter3"); rec.Code != http.StatusUnauthorized || strings.Contains(rec.Body.String(), "fmt.Println()") {
+		t.Errorf("POST with wrong password: status = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+	if rec := postPassword("hunter2"); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "fmt.Println()") {
+		t.Errorf("POST with password: status = %d, want the snippet", rec.Code)
+	}
+
+	if rec := getRaw(""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("raw without password: status = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+	if rec := getRaw("hunter3"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("raw with wrong password: status = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+	if rec := getRaw("hunter2"); rec.Code != http.StatusOK || rec.Body.String() != "fmt.Println()" {
+		t.Errorf("raw with password: status = %d, body = %q", rec.Code, rec.Body.String())
+	}
+
+	// the upload and both wrong passwords count against the rate limit
+	count, err := store.Get(context.Background(), h.rateLimiter.KeyValue("192.0.2.1"))
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if string(count) != "3" {
+		t.Errorf("rate limiter count = %s, want 3", count)
+	}
+}
+
+func TestHTTPServer_encryptedUpload(t *testing.T) {
+	h, store := newTestHTTPServer(t)
+	code := "const internal = true"
+	rec := httptest.NewRecorder()
+	h.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/snippets?encrypt", strings.NewReader(code)))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusCreated)
+	}
+	link := strings.TrimPrefix(strings.TrimSpace(rec.Body.String()), "http://localhost/c/")
+	key, secret, ok := strings.Cut(link, "#")
+	if !ok || secret == "" {
+		t.Fatalf("link %q has no key in its fragment", link)
+	}
+
+	raw, err := store.Get(context.Background(), key)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if strings.Contains(string(raw), code) {
+		t.Errorf("stored snippet is readable without the link")
+	}
+	record, err := DecodeSnippetRecord(raw)
+	if err != nil {
+		t.Fatalf("DecodeSnippetRecord() error = %v", err)
+	}
+	got, err := openSnippet(record.Code, secret)
+	if err != nil {
+		t.Fatalf("openSnippet() error = %v", err)
+	}
+	if string(got) != code {
+		t.Errorf("openSnippet() = %q, want %q", got, code)
+	}
+
+	rec = httptest.NewRecorder()
+	h.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/c/"+key, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if strings.Contains(rec.Body.String(), code) || !strings.Contains(rec.Body.String(), "data-sealed=") {
+		t.Errorf("code page doesn't leave decryption to the browser")
+	}
+}
+
+func TestHTTPServer_snippetEvents(t *testing.T) {
+	h, _ := newTestHTTPServer(t)
+	ctx := context.Background()
+	key, _, err := h.snippets.Create(ctx, []byte("fmt.Println()"), SnippetOptions{TTL: time.Minute, SnippetMeta: SnippetMeta{Owner: "SHA256:alice"}})
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	server := httptest.NewServer(h.Handler())
+	defer server.Close()
+	resp, err := http.Get(server.URL + "/c/" + key + "/events")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("Content-Type = %q, want text/event-stream", resp.Header.Get("Content-Type"))
+	}
+
+	// the viewer is watching once the headers have been received
+	_, err = h.snippets.Update(ctx, key, []byte("fmt.Println(\"fixed\")"), SnippetOptions{SnippetMeta: SnippetMeta{Owner: "SHA256:alice"}})
+	if err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+
+	line, err := bufio.NewReader(resp.Body).ReadString('\n')
+	if err != nil {
+		t.Fatalf("ReadString() error = %v", err)
+	}
+	if line != "event: updated\n" {
+		t.Errorf("event = %q, want %q", line, "event: updated\n")
+	}
+}
+
+func TestHTTPServer_tunnelEvents(t *testing.T) {
+	h, _ := newTestHTTPServer(t)
+	tunnel := NewTunnelData()
+	h.tunnelManager.AddTunnel("abc1234", tunnel)
+	if _, err := tunnel.Write([]byte("one\ntwo\n")); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+
+	server := httptest.NewServer(h.Handler())
+	defer server.Close()
+	resp, err := http.Get(server.URL + "/t/abc1234/events")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("Content-Type = %q, want text/event-stream", resp.Header.Get("Content-Type"))
+	}
+	// read the first line only, then the connection drops
+	reader := bufio.NewReader(resp.Body)
+	var received string
+	for !strings.HasSuffix(received, "\n\n") {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("ReadString() error = %v", err)
+		}
+		received += line
+	}
+	resp.Body.Close()
+	if received != "id: 4\ndata: one\n\n" {
+		t.Fatalf("first event = %q, want the first line", received)
+	}
+
+	if _, err := tunnel.Write([]byte("three\n")); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	tunnel.Close(nil)
+
+	req, err := http.NewRequest(http.MethodGet, server.URL+"/t/abc1234/events", nil)
+	if err != nil {
+		t.Fatalf("NewRequest() error = %v", err)
+	}
+	req.Header.Set("Last-Event-ID", "4")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Do() error = %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("ReadAll() error = %v", err)
+	}
+	want := "id: 8\ndata: two\n\nid: 14\ndata: three\n\nevent: done\ndata: \n\n"
+	if string(body) != want {
+		t.Errorf("events after reconnecting = %q, want %q", body, want)
+	}
+}
+
+func TestHTTPServer_revisions(t *testing.T) {
+	h, _ := newTestHTTPServer(t)
+	ctx := context.Background()
+	owner := SnippetMeta{Owner: "SHA256:alice"}
+	key, _, err := h.snippets.Create(ctx, []byte("port: 80\nhost: a\n"), SnippetOptions{TTL: time.Minute, SnippetMeta: owner})
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	_, err = h.snippets.Update(ctx, key, []byte("port: 8080\nhost: a\n"), SnippetOptions{SnippetMeta: owner})
+	if err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	files := []SnippetFile{{Path: "a.go", Offset: 0}, {Path: "b.go", Offset: 10}}
+	filesKey, _, err := h.snippets.Create(ctx, []byte("package a\npackage b\n"), SnippetOptions{TTL: time.Minute, Files: files, SnippetMeta: owner})
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	_, err = h.snippets.Update(ctx, filesKey, []byte("package a\n"), SnippetOptions{SnippetMeta: owner})
+	if err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+
+	tests := []struct {
+		path     string
+		wantCode int
+		wantBody string
+	}{
+		{path: "/c/" + key + "/v/1", wantCode: http.StatusOK, wantBody: "port: 80\n"},
+		{path: "/c/" + key + "/v/2", wantCode: http.StatusOK, wantBody: "port: 8080"},
+		{path: "/c/" + key + "/v/3", wantCode: http.StatusNotFound},
+		{
+			path:     "/c/" + key + "/diff/1..2",
+			wantCode: http.StatusOK,
+			wantBody: `<span class="diff-hunk">@@ -1,2 &#43;1,2 @@</span>` +
+				`<span class="diff-delete">-port: 80</span>` +
+				`<span class="diff-insert">&#43;port: 8080</span>` +
+				`<span class="diff-equal"> host: a</span>`,
+		},
+		{path: "/c/" + key + "/diff/2..2", wantCode: http.StatusOK, wantBody: "Revisions 2 and 2 are the same."},
+		{path: "/c/" + key + "/diff/1", wantCode: http.StatusNotFound},
+		// the files of a multi-file revision are diffed with their paths
+		{path: "/c/" + filesKey + "/diff/1..2", wantCode: http.StatusOK, wantBody: `<span class="diff-delete">-==&gt; b.go &lt;==</span>`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			h.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantCode)
+			}
+			if !strings.Contains(rec.Body.String(), tt.wantBody) {
+				t.Errorf("body doesn't contain %q:\n%s", tt.wantBody, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestHTTPServer_files(t *testing.T) {
+	h, _ := newTestHTTPServer(t)
+	code := []byte("package a\npackage b\n")
+	files := []SnippetFile{{Path: "a.go"}, {Path: "cmd/b.go", Offset: 10}}
+	key, _, err := h.snippets.Create(context.Background(), code, SnippetOptions{TTL: time.Minute, Files: files})
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	tests := []struct {
+		path     string
+		wantCode int
+		wantBody string
+	}{
+		{path: "/c/" + key, wantCode: http.StatusOK, wantBody: `<div class="file-path" id="cmd/b.go">`},
+		{path: "/c/" + key + "/cmd/b.go", wantCode: http.StatusOK, wantBody: "package b\n"},
+		{path: "/c/" + key + "/c.go", wantCode: http.StatusNotFound},
+		{path: "/r/" + key, wantCode: http.StatusOK, wantBody: "==> a.go <==\npackage a\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			h.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantCode)
+			}
+			if !strings.Contains(rec.Body.String(), tt.wantBody) {
+				t.Errorf("body doesn't contain %q:\n%s", tt.wantBody, rec.Body.String())
+			}
+		})
+	}
+
+	rec := httptest.NewRecorder()
+	h.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/c/"+key+"/cmd/b.go?download", nil))
+	if got := rec.Header().Get("Content-Disposition"); got != "attachment; filename=b.go" {
+		t.Errorf("Content-Disposition = %q, want %q", got, "attachment; filename=b.go")
+	}
+
+	// the files of a burned snippet are gone once the page is shown
+	burned, _, err := h.snippets.Create(context.Background(), code, SnippetOptions{TTL: time.Minute, Files: files, SnippetMeta: SnippetMeta{Burn: true}})
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	rec = httptest.NewRecorder()
+	h.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/c/"+burned, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if strings.Contains(rec.Body.String(), `class="file-raw"`) {
+		t.Errorf("burned snippet page links to the raw files")
+	}
+}
+
+func TestHTTPServer_missingFileKeepsSnippet(t *testing.T) {
+	h, _ := newTestHTTPServer(t)
+	ctx := context.Background()
+	files := []SnippetFile{{Path: "a.go"}, {Path: "b.go", Offset: 10}}
+
+	limits := map[string]SnippetMeta{"burn": {Burn: true}, "views": {Views: 1}}
+	for name, meta := range limits {
+		key, _, err := h.snippets.Create(ctx, []byte("package a\npackage b\n"), SnippetOptions{TTL: time.Minute, Files: files, SnippetMeta: meta})
+		if err != nil {
+			t.Fatalf("Create() error = %v", err)
+		}
+		rec := httptest.NewRecorder()
+		h.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/c/"+key+"/typo.go", nil))
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("%s: status of a missing file = %d, want %d", name, rec.Code, http.StatusNotFound)
+		}
+
+		rec = httptest.NewRecorder()
+		h.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/c/"+key+"/b.go", nil))
+		if rec.Code != http.StatusOK || rec.Body.String() != "package b\n" {
+			t.Errorf("%s: status after asking for a missing file = %d, want the file", name, rec.Code)
+		}
+	}
+}
+
+func TestHTTPServer_archives(t *testing.T) {
+	h, _ := newTestHTTPServer(t)
+	ctx := context.Background()
+	files := []SnippetFile{{Path: "a.go"}, {Path: "cmd/b.go", Offset: 10}}
+	key, _, err := h.snippets.Create(ctx, []byte("package a\npackage b\n"), SnippetOptions{TTL: time.Minute, Files: files})
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	want := map[string]string{"a.go": "package a\n", "cmd/b.go": "package b\n"}
+
+	rec := httptest.NewRecorder()
+	h.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/c/"+key+".zip", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if got := rec.Header().Get("Content-Disposition"); got != "attachment; filename="+key+".zip" {
+		t.Errorf("Content-Disposition = %q, want the key", got)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(rec.Body.Bytes()), int64(rec.Body.Len()))
+	if err != nil {
+		t.Fatalf("zip.NewReader() error = %v", err)
+	}
+	got := make(map[string]string)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatalf("Open() error = %v", err)
+		}
+		b, _ := io.ReadAll(rc)
+		rc.Close()
+		got[f.Name] = string(b)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("zip files = %v, want %v", got, want)
+	}
+
+	rec = httptest.NewRecorder()
+	h.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/c/"+key+".tar.gz", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	gr, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatalf("gzip.NewReader() error = %v", err)
+	}
+	tr := tar.NewReader(gr)
+	got = make(map[string]string)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Next() error = %v", err)
+		}
+		b, _ := io.ReadAll(tr)
+		got[header.Name] = string(b)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("tar files = %v, want %v", got, want)
+	}
+
+	single, _, err := h.snippets.Create(ctx, []byte("package main\n"), SnippetOptions{TTL: time.Minute})
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	rec = httptest.NewRecorder()
+	h.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/c/"+single+".zip", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+
+	// a single file with a view limit has no archive, asking for one isn't a view
+	limited, _, err := h.snippets.Create(ctx, []byte("package main\n"), SnippetOptions{TTL: time.Minute, SnippetMeta: SnippetMeta{Views: 2}})
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	for _, ext := range []string{".zip", ".tar.gz"} {
+		rec = httptest.NewRecorder()
+		h.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/c/"+limited+ext, nil))
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("%s status = %d, want %d", ext, rec.Code, http.StatusNotFound)
+		}
+	}
+	status, err := h.snippets.Status(ctx, limited)
+	if err != nil {
+		t.Fatalf("Status() error = %v", err)
+	}
+	if status.Consumed || status.ViewsLeft == nil || *status.ViewsLeft != 2 {
+		t.Errorf("status after asking for archives = %+v, want 2 views left", status)
+	}
+}
+
+func TestHTTPServer_clientIP(t *testing.T) {
+	proxies, err := ParseTrustedProxies("172.28.0.2, 10.0.0.0/8")
+	if err != nil {
+		t.Fatalf("ParseTrustedProxies() error = %v", err)
+	}
+	h := &HTTPServer{trustedProxies: proxies}
+
+	tests := []struct {
+		remoteAddr string
+		realIP     string
+		want       string
+	}{
+		{remoteAddr: "172.28.0.2:4242", realIP: "203.0.113.7", want: "203.0.113.7"},
+		{remoteAddr: "10.1.2.3:4242", realIP: "203.0.113.7", want: "203.0.113.7"},
+		{remoteAddr: "[::ffff:172.28.0.2]:4242", realIP: "203.0.113.7", want: "203.0.113.7"},
+		{remoteAddr: "198.51.100.1:4242", realIP: "203.0.113.7", want: "198.51.100.1"},
+		{remoteAddr: "172.28.0.2:4242", want: "172.28.0.2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.remoteAddr+" "+tt.realIP, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.realIP != "" {
+				r.Header.Set("X-Real-IP", tt.realIP)
+			}
+			if got := h.clientIP(r); got != tt.want {
+				t.Errorf("clientIP() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := ParseTrustedProxies("nginx"); err == nil {
+		t.Errorf("ParseTrustedProxies(nginx) succeeded, want an error")
+	}
+}
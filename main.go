@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
@@ -47,17 +48,104 @@ func main() {
 	}()
 	sugar := logger.Sugar()
 
-	redisClient := redis.NewClient(&redis.Options{
-		Addr:     fmt.Sprintf("%s:%s", GetRedisHostOrPanic(), GetRedisPortOrPanic()),
-		Password: GetRedisPassword(),
-		DB:       GetRedisDBOrPanic(),
-	})
-	redisStore := NewRedisStore(redisClient)
-	rateLimiter := NewRateLimiter(redisStore)
+	var backend RewritableStore
+	switch GetStoreBackendOrPanic() {
+	case StoreBackendMemory:
+		memoryStore := NewMemoryStore()
+		go func() {
+			for range time.Tick(MemoryStoreSweepInterval) {
+				sweptCount := memoryStore.Sweep()
+				sugar.Infow("swept expired keys", "deletedKeys", sweptCount)
+			}
+		}()
+		backend = memoryStore
+	case StoreBackendBolt:
+		boltStore, err := NewBoltStore(GetBoltPathOrPanic())
+		if err != nil {
+			sugar.Fatalw("failed to open bolt store", "error", err)
+			return
+		}
+		defer func() {
+			err := boltStore.Close()
+			if err != nil {
+				sugar.Errorw("failed to close bolt store", "error", err)
+			}
+		}()
+		go func() {
+			for range time.Tick(BoltStoreSweepInterval) {
+				sweptCount, err := boltStore.Sweep()
+				if err != nil {
+					sugar.Errorw("failed to sweep expired keys", "error", err)
+					continue
+				}
+				sugar.Infow("swept expired keys", "deletedKeys", sweptCount)
+			}
+		}()
+		backend = boltStore
+	default:
+		redisClient := redis.NewClient(&redis.Options{
+			Addr:     fmt.Sprintf("%s:%s", GetRedisHostOrPanic(), GetRedisPortOrPanic()),
+			Password: GetRedisPassword(),
+			DB:       GetRedisDBOrPanic(),
+		})
+		backend = NewRedisStore(redisClient)
+	}
+	sugar.Infow("using store", "backend", GetStoreBackendOrPanic())
+
+	var store Store = backend
+	if keyring := GetEncryptionKeyringOrPanic(); keyring != nil {
+		encryptedStore := NewEncryptedStore(backend, keyring)
+		go func() {
+			// rotate right away, a new key is usually added with a restart
+			ticker := time.NewTicker(EncryptedStoreRotateInterval)
+			for {
+				rotatedCount, skippedCount, err := encryptedStore.Rotate(context.Background(), func(key string, err error) {
+					sugar.Errorw("failed to decrypt value, skipped rotating it", "key", key, "error", err)
+				})
+				if err != nil {
+					sugar.Errorw("failed to rotate encryption keys", "error", err)
+				} else {
+					sugar.Infow("rotated encryption keys", "rotatedKeys", rotatedCount, "skippedKeys", skippedCount)
+				}
+				<-ticker.C
+			}
+		}()
+		store = encryptedStore
+		sugar.Infow("encrypting store at rest", "keyVersions", keyring.Versions())
+	}
+	// values are compressed before they are encrypted, ciphertext doesn't compress
+	if GetCompressionOrPanic() == CompressionGzip {
+		compressedStore := NewCompressedStore(store)
+		go func() {
+			for range time.Tick(CompressedStoreStatsInterval) {
+				stats := compressedStore.Stats()
+				sugar.Infow("compression stats",
+					"values", stats.Values,
+					"compressedValues", stats.CompressedValues,
+					"originalBytes", stats.OriginalBytes,
+					"storedBytes", stats.StoredBytes,
+					"ratio", stats.Ratio(),
+				)
+			}
+		}()
+		store = compressedStore
+		sugar.Infow("compressing store", "threshold", CompressionThreshold)
+	}
+	rateLimiter := NewRateLimiter(store)
+	snippets := NewSnippetManager(store, GetOversizedUploadsOrPanic() == OversizedUploadsReject, GetMaxRevisionsOrPanic())
 
 	chatCrawlerDetector := NewChatCrawlerDetector()
 	tunnelManager := NewTunnelManager()
-	httpServer := NewHTTPServer(sugar, redisStore, tunnelManager, chatCrawlerDetector)
+	httpServer := NewHTTPServer(
+		sugar,
+		store,
+		snippets,
+		rateLimiter,
+		tunnelManager,
+		chatCrawlerDetector,
+		GetHostOrPanic(),
+		GetTrustedProxiesOrPanic(),
+	)
 
 	go func() {
 		addr := fmt.Sprintf(":%s", GetHTTPPortOrPanic())
@@ -75,7 +163,7 @@ func main() {
 	}()
 
 	privateKey := ssh.HostKeyFile(GetPublicKeyOrPanic())
-	sshServer := NewSSHServer(sugar, redisStore, rateLimiter, tunnelManager, GetHostOrPanic())
+	sshServer := NewSSHServer(sugar, snippets, rateLimiter, tunnelManager, GetHostOrPanic())
 	sugar.Infow("starting ssh server", "addr", fmt.Sprintf(":%s", GetSSHPortOrPanic()))
 	if err := sshServer.ListenAndServe(fmt.Sprintf(":%s", GetSSHPortOrPanic()), nil, privateKey); err != nil {
 		sugar.Errorw("failed to start ssh server", "err", err)
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const MemoryStoreSweepInterval = time.Minute
+
+type memoryItem struct {
+	value []byte
+	// members is only set for sets
+	members   map[string]struct{}
+	expiresAt time.Time
+}
+
+func (i memoryItem) expired(now time.Time) bool {
+	return !i.expiresAt.IsZero() && !now.Before(i.expiresAt)
+}
+
+// MemoryStore is an in-process Store. Expired keys are never returned, and
+// Sweep removes them so they don't pile up in memory.
+type MemoryStore struct {
+	items map[string]memoryItem
+	lock  sync.Mutex
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		items: make(map[string]memoryItem),
+	}
+}
+
+func (m *MemoryStore) Set(_ context.Context, key string, value any, ttl time.Duration) error {
+	b, err := valueToBytes(value)
+	if err != nil {
+		return err
+	}
+
+	item := memoryItem{value: append([]byte(nil), b...)}
+	if ttl > 0 {
+		item.expiresAt = time.Now().Add(ttl)
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.items[key] = item
+	return nil
+}
+
+func (m *MemoryStore) Get(_ context.Context, key string) ([]byte, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	item, ok := m.getItem(key)
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	if item.members != nil {
+		return nil, ErrWrongType
+	}
+	return append([]byte(nil), item.value...), nil
+}
+
+func (m *MemoryStore) GetDel(_ context.Context, key string) ([]byte, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	item, ok := m.getItem(key)
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	if item.members != nil {
+		return nil, ErrWrongType
+	}
+	delete(m.items, key)
+	return item.value, nil
+}
+
+func (m *MemoryStore) Del(_ context.Context, key string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	delete(m.items, key)
+	return nil
+}
+
+// Incr behaves like the redis INCR command: missing keys start at 0 and the
+// ttl of an existing key is kept.
+func (m *MemoryStore) Incr(_ context.Context, key string) (int64, error) {
+	return m.incrBy(key, 1)
+}
+
+// Decr behaves like the redis DECR command, see Incr.
+func (m *MemoryStore) Decr(_ context.Context, key string) (int64, error) {
+	return m.incrBy(key, -1)
+}
+
+func (m *MemoryStore) incrBy(key string, delta int64) (int64, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	item, ok := m.getItem(key)
+	count := int64(0)
+	if ok {
+		var err error
+		count, err = strconv.ParseInt(string(item.value), 10, 64)
+		if err != nil {
+			return 0, ErrNotInteger
+		}
+	}
+	count += delta
+	item.value = strconv.AppendInt(nil, count, 10)
+	m.items[key] = item
+	return count, nil
+}
+
+func (m *MemoryStore) Expire(_ context.Context, key string, ttl time.Duration) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	item, ok := m.getItem(key)
+	if !ok {
+		return nil
+	}
+	if ttl <= 0 {
+		delete(m.items, key)
+		return nil
+	}
+	item.expiresAt = time.Now().Add(ttl)
+	m.items[key] = item
+	return nil
+}
+
+func (m *MemoryStore) SAdd(_ context.Context, key string, members ...string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	item, ok := m.getItem(key)
+	if !ok {
+		item = memoryItem{members: make(map[string]struct{})}
+	}
+	if item.members == nil {
+		return ErrWrongType
+	}
+	for _, member := range members {
+		item.members[member] = struct{}{}
+	}
+	m.items[key] = item
+	return nil
+}
+
+// SRem removes members from a set and, like redis, the set once it is empty.
+func (m *MemoryStore) SRem(_ context.Context, key string, members ...string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	item, ok := m.getItem(key)
+	if !ok {
+		return nil
+	}
+	if item.members == nil {
+		return ErrWrongType
+	}
+	for _, member := range members {
+		delete(item.members, member)
+	}
+	if len(item.members) == 0 {
+		delete(m.items, key)
+	}
+	return nil
+}
+
+func (m *MemoryStore) SMembers(_ context.Context, key string) ([]string, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	item, ok := m.getItem(key)
+	if !ok {
+		return nil, nil
+	}
+	if item.members == nil {
+		return nil, ErrWrongType
+	}
+	members := make([]string, 0, len(item.members))
+	for member := range item.members {
+		members = append(members, member)
+	}
+	return members, nil
+}
+
+// Scan calls fn for a snapshot of the keys, so fn may use the store.
+func (m *MemoryStore) Scan(_ context.Context, fn func(key string) error) error {
+	m.lock.Lock()
+	now := time.Now()
+	keys := make([]string, 0, len(m.items))
+	for key, item := range m.items {
+		if !item.expired(now) && item.members == nil {
+			keys = append(keys, key)
+		}
+	}
+	m.lock.Unlock()
+
+	for _, key := range keys {
+		if err := fn(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) CompareAndSwap(_ context.Context, key string, oldValue, newValue []byte) (bool, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	item, ok := m.getItem(key)
+	if !ok || !bytes.Equal(item.value, oldValue) {
+		return false, nil
+	}
+	item.value = append([]byte(nil), newValue...)
+	m.items[key] = item
+	return true, nil
+}
+
+// Sweep removes all expired keys and returns how many were removed.
+func (m *MemoryStore) Sweep() uint {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	now := time.Now()
+	sweptCount := uint(0)
+	for key, item := range m.items {
+		if item.expired(now) {
+			delete(m.items, key)
+			sweptCount++
+		}
+	}
+	return sweptCount
+}
+
+func (m *MemoryStore) getItem(key string) (memoryItem, bool) {
+	item, ok := m.items[key]
+	if !ok {
+		return memoryItem{}, false
+	}
+	if item.expired(time.Now()) {
+		delete(m.items, key)
+		return memoryItem{}, false
+	}
+	return item, true
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMemoryStore_SetGet(t *testing.T) {
+	tests := []struct {
+		name  string
+		value any
+		want  string
+	}{
+		{name: "string", value: "package main", want: "package main"},
+		{name: "bytes", value: []byte("fmt.Println()"), want: "fmt.Println()"},
+		{name: "int", value: 42, want: "42"},
+	}
+
+	ctx := context.Background()
+	m := NewMemoryStore()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := m.Set(ctx, tt.name, tt.value, time.Minute); err != nil {
+				t.Fatalf("Set() error = %v", err)
+			}
+			got, err := m.Get(ctx, tt.name)
+			if err != nil {
+				t.Fatalf("Get() error = %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("Get() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMemoryStore_TTL(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemoryStore()
+
+	if err := m.Set(ctx, "short", "code", 10*time.Millisecond); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if err := m.Set(ctx, "forever", "code", 0); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	if _, err := m.Get(ctx, "short"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Get() error = %v, want %v", err, ErrKeyNotFound)
+	}
+	if _, err := m.Get(ctx, "forever"); err != nil {
+		t.Errorf("Get() error = %v", err)
+	}
+}
+
+func TestMemoryStore_Sweep(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemoryStore()
+
+	for _, key := range []string{"a", "b"} {
+		if err := m.Set(ctx, key, "code", 10*time.Millisecond); err != nil {
+			t.Fatalf("Set() error = %v", err)
+		}
+	}
+	if err := m.Set(ctx, "c", "code", time.Minute); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	if got := m.Sweep(); got != 2 {
+		t.Errorf("Sweep() = %d, want 2", got)
+	}
+	if got := len(m.items); got != 1 {
+		t.Errorf("items left = %d, want 1", got)
+	}
+}
+
+func TestMemoryStore_IncrExpire(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemoryStore()
+
+	for want := int64(1); want <= 3; want++ {
+		got, err := m.Incr(ctx, "counter")
+		if err != nil {
+			t.Fatalf("Incr() error = %v", err)
+		}
+		if got != want {
+			t.Errorf("Incr() = %d, want %d", got, want)
+		}
+	}
+
+	if err := m.Expire(ctx, "counter", 10*time.Millisecond); err != nil {
+		t.Fatalf("Expire() error = %v", err)
+	}
+	if _, err := m.Incr(ctx, "counter"); err != nil {
+		t.Fatalf("Incr() error = %v", err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if _, err := m.Get(ctx, "counter"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Get() error = %v, want %v", err, ErrKeyNotFound)
+	}
+
+	if err := m.Set(ctx, "code", "package main", time.Minute); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if _, err := m.Incr(ctx, "code"); !errors.Is(err, ErrNotInteger) {
+		t.Errorf("Incr() error = %v, want %v", err, ErrNotInteger)
+	}
+}
+
+func TestMemoryStore_GetDel(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemoryStore()
+
+	if err := m.Set(ctx, "abc1234", "secret", time.Minute); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	got, err := m.GetDel(ctx, "abc1234")
+	if err != nil {
+		t.Fatalf("GetDel() error = %v", err)
+	}
+	if string(got) != "secret" {
+		t.Errorf("GetDel() = %q, want %q", got, "secret")
+	}
+	if _, err := m.GetDel(ctx, "abc1234"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("second GetDel() error = %v, want %v", err, ErrKeyNotFound)
+	}
+}
+
+func TestMemoryStore_Decr(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemoryStore()
+
+	if err := m.Set(ctx, "views", 2, 10*time.Millisecond); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	for _, want := range []int64{1, 0, -1} {
+		got, err := m.Decr(ctx, "views")
+		if err != nil {
+			t.Fatalf("Decr() error = %v", err)
+		}
+		if got != want {
+			t.Errorf("Decr() = %d, want %d", got, want)
+		}
+	}
+
+	// the ttl is kept
+	time.Sleep(20 * time.Millisecond)
+	if _, err := m.Get(ctx, "views"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Get() error = %v, want %v", err, ErrKeyNotFound)
+	}
+}
+
+func TestMemoryStore_Sets(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemoryStore()
+
+	if err := m.SAdd(ctx, "owner", "abc1234", "def5678", "abc1234"); err != nil {
+		t.Fatalf("SAdd() error = %v", err)
+	}
+	if err := m.SRem(ctx, "owner", "def5678"); err != nil {
+		t.Fatalf("SRem() error = %v", err)
+	}
+	got, err := m.SMembers(ctx, "owner")
+	if err != nil {
+		t.Fatalf("SMembers() error = %v", err)
+	}
+	if len(got) != 1 || got[0] != "abc1234" {
+		t.Errorf("SMembers() = %q, want [abc1234]", got)
+	}
+	if _, err := m.Get(ctx, "owner"); !errors.Is(err, ErrWrongType) {
+		t.Errorf("Get() error = %v, want %v", err, ErrWrongType)
+	}
+
+	// a set without members is deleted
+	if err := m.SRem(ctx, "owner", "abc1234"); err != nil {
+		t.Fatalf("SRem() error = %v", err)
+	}
+	if got, err := m.SMembers(ctx, "owner"); err != nil || len(got) != 0 {
+		t.Errorf("SMembers() = %q, %v, want no members", got, err)
+	}
+}
@@ -27,9 +27,19 @@ func (r *RateLimiter) KeyValue(key string) string {
 	return fmt.Sprintf("rate_limiter:%s", key)
 }
 
+// IsRateLimited reports whether key has used up its attempts and, if not,
+// counts this one.
 func (r *RateLimiter) IsRateLimited(ctx context.Context, key string) (bool, error) {
-	key = r.KeyValue(key)
-	currentValue, err := r.store.Get(ctx, key)
+	limited, err := r.Reached(ctx, key)
+	if err != nil || limited {
+		return limited, err
+	}
+	return false, r.Count(ctx, key)
+}
+
+// Reached reports whether key has used up its attempts without counting one.
+func (r *RateLimiter) Reached(ctx context.Context, key string) (bool, error) {
+	currentValue, err := r.store.Get(ctx, r.KeyValue(key))
 	if err != nil && !errors.Is(err, ErrKeyNotFound) {
 		return false, err
 	}
@@ -40,18 +50,18 @@ func (r *RateLimiter) IsRateLimited(ctx context.Context, key string) (bool, erro
 	if err != nil {
 		return false, err
 	}
-	if count >= MaxAttempts {
-		return true, nil
-	}
+	return count >= MaxAttempts, nil
+}
+
+// Count counts an attempt, e.g. a wrong password, against key.
+func (r *RateLimiter) Count(ctx context.Context, key string) error {
+	key = r.KeyValue(key)
 	val, err := r.store.Incr(ctx, key)
 	if err != nil {
-		return false, err
+		return err
 	}
 	if val == 1 {
-		err = r.store.Expire(ctx, key, RateLimiterTTL)
-		if err != nil {
-			return false, err
-		}
+		return r.store.Expire(ctx, key, RateLimiterTTL)
 	}
-	return false, nil
+	return nil
 }
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestRateLimiter_IsRateLimited(t *testing.T) {
+	ctx := context.Background()
+	r := NewRateLimiter(NewMemoryStore())
+
+	for i := 0; i < MaxAttempts; i++ {
+		limited, err := r.IsRateLimited(ctx, "127.0.0.1")
+		if err != nil {
+			t.Fatalf("IsRateLimited() error = %v", err)
+		}
+		if limited {
+			t.Fatalf("IsRateLimited() = true after %d attempts", i)
+		}
+	}
+
+	limited, err := r.IsRateLimited(ctx, "127.0.0.1")
+	if err != nil {
+		t.Fatalf("IsRateLimited() error = %v", err)
+	}
+	if !limited {
+		t.Errorf("IsRateLimited() = false after %d attempts", MaxAttempts)
+	}
+
+	limited, err = r.IsRateLimited(ctx, "10.0.0.1")
+	if err != nil {
+		t.Fatalf("IsRateLimited() error = %v", err)
+	}
+	if limited {
+		t.Errorf("IsRateLimited() = true for a different key")
+	}
+}
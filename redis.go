@@ -23,6 +23,10 @@ func (r *RedisStore) Incr(ctx context.Context, key string) (int64, error) {
 	return r.redisClient.Incr(ctx, key).Result()
 }
 
+func (r *RedisStore) Decr(ctx context.Context, key string) (int64, error) {
+	return r.redisClient.Decr(ctx, key).Result()
+}
+
 func (r *RedisStore) Get(ctx context.Context, key string) ([]byte, error) {
 	res, err := r.redisClient.Get(ctx, key).Bytes()
 	switch {
@@ -34,6 +38,17 @@ func (r *RedisStore) Get(ctx context.Context, key string) ([]byte, error) {
 	return res, nil
 }
 
+func (r *RedisStore) GetDel(ctx context.Context, key string) ([]byte, error) {
+	res, err := r.redisClient.GetDel(ctx, key).Bytes()
+	switch {
+	case errors.Is(err, redis.Nil):
+		return nil, ErrKeyNotFound
+	case err != nil:
+		return nil, err
+	}
+	return res, nil
+}
+
 func (r *RedisStore) Del(ctx context.Context, key string) error {
 	return r.redisClient.Del(ctx, key).Err()
 }
@@ -41,3 +56,50 @@ func (r *RedisStore) Del(ctx context.Context, key string) error {
 func (r *RedisStore) Expire(ctx context.Context, key string, ttl time.Duration) error {
 	return r.redisClient.Expire(ctx, key, ttl).Err()
 }
+
+func (r *RedisStore) SAdd(ctx context.Context, key string, members ...string) error {
+	return r.redisClient.SAdd(ctx, key, stringsToAny(members)...).Err()
+}
+
+func (r *RedisStore) SRem(ctx context.Context, key string, members ...string) error {
+	return r.redisClient.SRem(ctx, key, stringsToAny(members)...).Err()
+}
+
+func (r *RedisStore) SMembers(ctx context.Context, key string) ([]string, error) {
+	return r.redisClient.SMembers(ctx, key).Result()
+}
+
+func stringsToAny(s []string) []any {
+	a := make([]any, len(s))
+	for i, v := range s {
+		a[i] = v
+	}
+	return a
+}
+
+func (r *RedisStore) Scan(ctx context.Context, fn func(key string) error) error {
+	iter := r.redisClient.ScanType(ctx, 0, "", 0, "string").Iterator()
+	for iter.Next(ctx) {
+		if err := fn(iter.Val()); err != nil {
+			return err
+		}
+	}
+	return iter.Err()
+}
+
+// compareAndSwapScript needs redis 6 or later for KEEPTTL.
+var compareAndSwapScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	redis.call("SET", KEYS[1], ARGV[2], "KEEPTTL")
+	return 1
+end
+return 0
+`)
+
+func (r *RedisStore) CompareAndSwap(ctx context.Context, key string, oldValue, newValue []byte) (bool, error) {
+	swapped, err := compareAndSwapScript.Run(ctx, r.redisClient, []string{key}, oldValue, newValue).Int()
+	if err != nil {
+		return false, err
+	}
+	return swapped == 1, nil
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// maxSCPLineLength bounds the control lines of the scp protocol, which are
+// the file mode, size and name.
+const maxSCPLineLength = 1024
+
+var (
+	ErrSCPProtocol     = errors.New("scp protocol error")
+	ErrCopyRateLimited = errors.New("you have been rate limited, please try again later")
+)
+
+// IsSCPCommand reports whether args are what scp runs on the server it
+// copies to or from.
+func IsSCPCommand(args []string) bool {
+	return len(args) > 0 && args[0] == "scp"
+}
+
+// isSCPSink reports whether scp was run with -t, receiving the files
+// being copied, rather than with -f to send them.
+func isSCPSink(args []string) bool {
+	for _, arg := range args[1:] {
+		if arg == "--" || !strings.HasPrefix(arg, "-") {
+			break
+		}
+		if strings.ContainsRune(arg, 't') {
+			return true
+		}
+	}
+	return false
+}
+
+// ReadSCP receives files the way scp -t does and returns them as the code
+// of a multi-file snippet, with the same limits as ReadFiles. Every file
+// and directory the source announces is acknowledged on rw, errors are
+// left to the caller to report.
+func (m *SnippetManager) ReadSCP(rw io.ReadWriter) ([]byte, []SnippetFile, error) {
+	r := bufio.NewReader(io.LimitReader(rw, MaxStreamSize))
+	var code []byte
+	var files []SnippetFile
+	var dirs []string
+	seen := make(map[string]bool)
+
+	// the source waits for the sink to be ready
+	err := scpAck(rw)
+	if err != nil {
+		return nil, nil, err
+	}
+	for {
+		line, err := readSCPLine(r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		switch line[0] {
+		case '\x01', '\x02':
+			// scp on the client side failed, e.g. to read a file
+			return nil, nil, fmt.Errorf("%w: %s", ErrSCPProtocol, line[1:])
+		case 'T':
+			// modification times sent with scp -p, snippets keep their own
+		case 'E':
+			if len(dirs) == 0 {
+				return nil, nil, fmt.Errorf("%w: unexpected end of directory", ErrSCPProtocol)
+			}
+			dirs = dirs[:len(dirs)-1]
+		case 'D':
+			_, name, err := parseSCPEntry(line)
+			if err != nil {
+				return nil, nil, err
+			}
+			dirs = append(dirs, name)
+		case 'C':
+			size, name, err := parseSCPEntry(line)
+			if err != nil {
+				return nil, nil, err
+			}
+			filePath, err := ParseFilePath(path.Join(append(dirs, name)...))
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid file %q: %w", name, err)
+			}
+			if seen[filePath] {
+				return nil, nil, fmt.Errorf("file %q is copied more than once", filePath)
+			}
+			seen[filePath] = true
+			if len(files) == MaxFiles {
+				return nil, nil, fmt.Errorf("more than %d files were copied", MaxFiles)
+			}
+			if size > int64(MaxUploadSize-len(code)) {
+				return nil, nil, &UploadTooLargeError{Received: int64(len(code)) + size}
+			}
+
+			err = scpAck(rw)
+			if err != nil {
+				return nil, nil, err
+			}
+			files = append(files, SnippetFile{Path: filePath, Offset: len(code)})
+			content := make([]byte, size)
+			_, err = io.ReadFull(r, content)
+			if err != nil {
+				return nil, nil, fmt.Errorf("%w: %v", ErrSCPProtocol, err)
+			}
+			code = append(code, content...)
+
+			// the source follows the file with its status
+			status, err := r.ReadByte()
+			if err != nil {
+				return nil, nil, fmt.Errorf("%w: %v", ErrSCPProtocol, err)
+			}
+			if status != 0 {
+				message, _ := readSCPLine(r)
+				return nil, nil, fmt.Errorf("%w: %s", ErrSCPProtocol, message)
+			}
+		default:
+			return nil, nil, fmt.Errorf("%w: unexpected %q", ErrSCPProtocol, line)
+		}
+
+		err = scpAck(rw)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	if len(files) == 0 {
+		return nil, nil, fmt.Errorf("%w: no files were copied", ErrSCPProtocol)
+	}
+	return code, files, nil
+}
+
+// WriteSCPError sends err to the other end of scp, which shows it and
+// gives up.
+func WriteSCPError(w io.Writer, err error) error {
+	message := strings.ReplaceAll(err.Error(), "\n", " ")
+	_, err = fmt.Fprintf(w, "\x02codesnap.sh: %s\n", message)
+	return err
+}
+
+func scpAck(w io.Writer) error {
+	_, err := w.Write([]byte{0})
+	return err
+}
+
+func readSCPLine(r *bufio.Reader) (string, error) {
+	var b strings.Builder
+	for {
+		c, err := r.ReadByte()
+		if err == io.EOF && b.Len() > 0 {
+			return "", fmt.Errorf("%w: %v", ErrSCPProtocol, io.ErrUnexpectedEOF)
+		}
+		if err != nil {
+			return "", err
+		}
+		if c == '\n' {
+			break
+		}
+		if b.Len() == maxSCPLineLength {
+			return "", fmt.Errorf("%w: line too long", ErrSCPProtocol)
+		}
+		b.WriteByte(c)
+	}
+	if b.Len() == 0 {
+		return "", fmt.Errorf("%w: empty line", ErrSCPProtocol)
+	}
+	return b.String(), nil
+}
+
+// parseSCPEntry parses the line announcing a file or a directory, e.g.
+// "C0644 42 main.go", into its size and name.
+func parseSCPEntry(line string) (int64, string, error) {
+	fields := strings.SplitN(line[1:], " ", 3)
+	if len(fields) != 3 {
+		return 0, "", fmt.Errorf("%w: unexpected %q", ErrSCPProtocol, line)
+	}
+	_, err := strconv.ParseUint(fields[0], 8, 32)
+	if err != nil {
+		return 0, "", fmt.Errorf("%w: invalid mode %q", ErrSCPProtocol, fields[0])
+	}
+	size, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil || size < 0 {
+		return 0, "", fmt.Errorf("%w: invalid size %q", ErrSCPProtocol, fields[1])
+	}
+	name := fields[2]
+	if name == "" || name == "." || name == ".." || strings.Contains(name, "/") {
+		return 0, "", fmt.Errorf("%w: invalid name %q", ErrSCPProtocol, name)
+	}
+	return size, name, nil
+}
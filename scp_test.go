@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+// scpConn plays the source side of scp: it sends in, and records the
+// acknowledgements of the sink.
+type scpConn struct {
+	io.Reader
+	acks bytes.Buffer
+}
+
+func (c *scpConn) Write(p []byte) (int, error) {
+	return c.acks.Write(p)
+}
+
+func TestIsSCPCommand(t *testing.T) {
+	tests := []struct {
+		args     []string
+		wantSCP  bool
+		wantSink bool
+	}{
+		{args: []string{"scp", "-t", "."}, wantSCP: true, wantSink: true},
+		{args: []string{"scp", "-v", "-d", "-t", "--", "."}, wantSCP: true, wantSink: true},
+		{args: []string{"scp", "-f", "abc1234"}, wantSCP: true},
+		{args: []string{"scp", "--", "-t"}, wantSCP: true},
+		{args: []string{"ttl=600"}},
+		{args: nil},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.args, " "), func(t *testing.T) {
+			if got := IsSCPCommand(tt.args); got != tt.wantSCP {
+				t.Errorf("IsSCPCommand() = %v, want %v", got, tt.wantSCP)
+			}
+			if tt.wantSCP {
+				if got := isSCPSink(tt.args); got != tt.wantSink {
+					t.Errorf("isSCPSink() = %v, want %v", got, tt.wantSink)
+				}
+			}
+		})
+	}
+}
+
+func TestSnippetManager_ReadSCP(t *testing.T) {
+	m := NewSnippetManager(NewMemoryStore(), false, DefaultMaxRevisions)
+	conn := &scpConn{Reader: strings.NewReader(
+		"T1700000000 0 1700000000 0\n" +
+			"C0644 10 a.go\npackage a\n\x00" +
+			"D0755 0 cmd\nC0644 10 b.go\npackage b\n\x00E\n",
+	)}
+	code, files, err := m.ReadSCP(conn)
+	if err != nil {
+		t.Fatalf("ReadSCP() error = %v", err)
+	}
+	record := &SnippetRecord{Code: code, Files: files}
+	if got, _ := record.File("cmd/b.go"); string(got) != "package b\n" {
+		t.Errorf("File(cmd/b.go) = %q, want %q", got, "package b\n")
+	}
+	// one to start and one for every line and file
+	if got := conn.acks.Len(); got != 8 {
+		t.Errorf("acks = %d, want 8", got)
+	}
+
+	tests := []struct {
+		name    string
+		in      string
+		wantErr error
+	}{
+		{name: "no files", in: "", wantErr: ErrSCPProtocol},
+		{name: "escaping name", in: "C0644 1 ../a\na\x00", wantErr: ErrSCPProtocol},
+		{name: "unbalanced directory", in: "E\n", wantErr: ErrSCPProtocol},
+		{name: "source error", in: "\x01scp: a.go: Permission denied\n", wantErr: ErrSCPProtocol},
+		{name: "short file", in: "C0644 10 a.go\npack", wantErr: ErrSCPProtocol},
+		{name: "too large", in: fmt.Sprintf("C0644 %d a.go\n", MaxUploadSize+1)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := m.ReadSCP(&scpConn{Reader: strings.NewReader(tt.in)})
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("ReadSCP() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			var tooLargeErr *UploadTooLargeError
+			if !errors.As(err, &tooLargeErr) {
+				t.Errorf("ReadSCP() error = %v, want %T", err, tooLargeErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp"
+)
+
+// SFTPUpload collects the files an SFTP client writes, which is how scp
+// copies from OpenSSH 9.0 on. Nothing can be read back: the server has
+// only the directories the client made and the files it wrote, with the
+// same limits as ReadSCP.
+type SFTPUpload struct {
+	files []*sftpFile
+	dirs  map[string]bool
+	size  int
+	// err is the first limit that was hit, the upload fails with it
+	err  error
+	lock sync.Mutex
+}
+
+func NewSFTPUpload() *SFTPUpload {
+	return &SFTPUpload{dirs: map[string]bool{"/": true}}
+}
+
+// Handlers returns the handlers to serve the upload with sftp.NewRequestServer.
+func (u *SFTPUpload) Handlers() sftp.Handlers {
+	return sftp.Handlers{FileGet: u, FilePut: u, FileCmd: u, FileList: u}
+}
+
+// Files returns the code of the written files as a multi-file snippet, in
+// the order they were created.
+func (u *SFTPUpload) Files() ([]byte, []SnippetFile, error) {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
+	if u.err != nil {
+		return nil, nil, u.err
+	}
+	if len(u.files) == 0 {
+		return nil, nil, fmt.Errorf("no files were copied")
+	}
+	code := make([]byte, 0, u.size)
+	files := make([]SnippetFile, 0, len(u.files))
+	for _, file := range u.files {
+		files = append(files, SnippetFile{Path: file.path, Offset: len(code)})
+		code = append(code, file.data...)
+	}
+	return code, files, nil
+}
+
+func (u *SFTPUpload) Fileread(r *sftp.Request) (io.ReaderAt, error) {
+	return nil, sftp.ErrSSHFxPermissionDenied
+}
+
+func (u *SFTPUpload) Filewrite(r *sftp.Request) (io.WriterAt, error) {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
+	filePath, err := ParseFilePath(r.Filepath)
+	if err != nil {
+		return nil, err
+	}
+	if !u.dirs[path.Dir("/"+filePath)] {
+		return nil, sftp.ErrSSHFxNoSuchFile
+	}
+	if u.file(filePath) != nil {
+		return nil, fmt.Errorf("file %q is copied more than once", filePath)
+	}
+	if len(u.files) == MaxFiles {
+		u.err = fmt.Errorf("more than %d files were copied", MaxFiles)
+		return nil, u.err
+	}
+	file := &sftpFile{upload: u, path: filePath}
+	u.files = append(u.files, file)
+	return file, nil
+}
+
+// Filecmd makes directories and accepts setting file attributes, which scp
+// does when it is run with -p, without changing anything.
+func (u *SFTPUpload) Filecmd(r *sftp.Request) error {
+	switch r.Method {
+	case "Setstat":
+		return nil
+	case "Mkdir":
+		u.lock.Lock()
+		defer u.lock.Unlock()
+		dir, err := ParseFilePath(r.Filepath)
+		if err != nil {
+			return err
+		}
+		u.dirs["/"+dir] = true
+		return nil
+	}
+	return sftp.ErrSSHFxOpUnsupported
+}
+
+func (u *SFTPUpload) Filelist(r *sftp.Request) (sftp.ListerAt, error) {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
+	name := path.Clean("/" + r.Filepath)
+	var info os.FileInfo
+	switch {
+	case u.dirs[name]:
+		info = sftpFileInfo{name: path.Base(name), dir: true}
+	case u.file(name[1:]) != nil:
+		info = sftpFileInfo{name: path.Base(name), size: int64(len(u.file(name[1:]).data))}
+	default:
+		return nil, sftp.ErrSSHFxNoSuchFile
+	}
+
+	switch r.Method {
+	case "Stat":
+		return sftpListerAt{info}, nil
+	case "List":
+		// nothing is listed, snippets can't be read over SFTP
+		if !info.IsDir() {
+			return nil, sftp.ErrSSHFxNoSuchFile
+		}
+		return sftpListerAt{}, nil
+	}
+	return nil, sftp.ErrSSHFxOpUnsupported
+}
+
+func (u *SFTPUpload) file(filePath string) *sftpFile {
+	i := slices.IndexFunc(u.files, func(file *sftpFile) bool { return file.path == filePath })
+	if i < 0 {
+		return nil
+	}
+	return u.files[i]
+}
+
+type sftpFile struct {
+	upload *SFTPUpload
+	path   string
+	data   []byte
+}
+
+func (f *sftpFile) WriteAt(p []byte, off int64) (int, error) {
+	u := f.upload
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
+	if u.err != nil {
+		return 0, u.err
+	}
+	if off < 0 || off > int64(MaxUploadSize) {
+		u.err = &UploadTooLargeError{Received: int64(u.size) + max(off, 0)}
+		return 0, u.err
+	}
+	end := int(off) + len(p)
+	if grown := end - len(f.data); grown > 0 {
+		if u.size+grown > MaxUploadSize {
+			u.err = &UploadTooLargeError{Received: int64(u.size + grown)}
+			return 0, u.err
+		}
+		u.size += grown
+		f.data = append(f.data, make([]byte, grown)...)
+	}
+	return copy(f.data[off:], p), nil
+}
+
+type sftpFileInfo struct {
+	name string
+	size int64
+	dir  bool
+}
+
+func (i sftpFileInfo) Name() string { return i.name }
+func (i sftpFileInfo) Size() int64  { return i.size }
+func (i sftpFileInfo) Mode() os.FileMode {
+	if i.dir {
+		return os.ModeDir | 0o755
+	}
+	return 0o644
+}
+func (i sftpFileInfo) ModTime() time.Time { return time.Time{} }
+func (i sftpFileInfo) IsDir() bool        { return i.dir }
+func (i sftpFileInfo) Sys() any           { return nil }
+
+type sftpListerAt []os.FileInfo
+
+func (l sftpListerAt) ListAt(infos []os.FileInfo, offset int64) (int, error) {
+	if offset >= int64(len(l)) {
+		return 0, io.EOF
+	}
+	n := copy(infos, l[offset:])
+	if n < len(infos) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// ReadSFTP serves an SFTP upload on rw until the client is done and
+// returns the files it wrote like ReadSCP does.
+func (m *SnippetManager) ReadSFTP(rw io.ReadWriteCloser) ([]byte, []SnippetFile, error) {
+	upload := NewSFTPUpload()
+	server := sftp.NewRequestServer(rw, upload.Handlers())
+	err := server.Serve()
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, nil, err
+	}
+	return upload.Files()
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/pkg/sftp"
+)
+
+type pipeConn struct {
+	io.Reader
+	io.WriteCloser
+}
+
+// readSFTP runs ReadSFTP against an SFTP client doing copy and returns
+// what it read.
+func readSFTP(t *testing.T, copy func(client *sftp.Client)) ([]byte, []SnippetFile, error) {
+	t.Helper()
+	m := NewSnippetManager(NewMemoryStore(), false, DefaultMaxRevisions)
+	clientR, serverW := io.Pipe()
+	serverR, clientW := io.Pipe()
+
+	type result struct {
+		code  []byte
+		files []SnippetFile
+		err   error
+	}
+	results := make(chan result, 1)
+	go func() {
+		code, files, err := m.ReadSFTP(pipeConn{Reader: serverR, WriteCloser: serverW})
+		serverW.Close()
+		results <- result{code, files, err}
+	}()
+
+	client, err := sftp.NewClientPipe(clientR, clientW)
+	if err != nil {
+		t.Fatalf("NewClientPipe() error = %v", err)
+	}
+	copy(client)
+	client.Close()
+	res := <-results
+	return res.code, res.files, res.err
+}
+
+func putFile(client *sftp.Client, name, content string) error {
+	f, err := client.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write([]byte(content))
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func TestSnippetManager_ReadSFTP(t *testing.T) {
+	code, files, err := readSFTP(t, func(client *sftp.Client) {
+		// what scp does: check the target is a directory, then write into it
+		info, err := client.Stat(".")
+		if err != nil || !info.IsDir() {
+			t.Errorf("Stat(.) = %v, %v, want a directory", info, err)
+		}
+		if err := putFile(client, "a.go", "package a\n"); err != nil {
+			t.Errorf("putFile(a.go) error = %v", err)
+		}
+		if err := client.Mkdir("cmd"); err != nil {
+			t.Errorf("Mkdir() error = %v", err)
+		}
+		if err := putFile(client, "cmd/b.go", "package b\n"); err != nil {
+			t.Errorf("putFile(cmd/b.go) error = %v", err)
+		}
+		if err := putFile(client, "missing/c.go", "package c\n"); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("putFile(missing/c.go) error = %v, want %v", err, os.ErrNotExist)
+		}
+		if _, err := client.Open("a.go"); err == nil {
+			t.Errorf("Open() for reading succeeded, want an error")
+		}
+	})
+	if err != nil {
+		t.Fatalf("ReadSFTP() error = %v", err)
+	}
+	record := &SnippetRecord{Code: code, Files: files}
+	want := "==> a.go <==\npackage a\n\n==> cmd/b.go <==\npackage b\n"
+	if got := string(record.JoinedCode()); got != want {
+		t.Errorf("JoinedCode() = %q, want %q", got, want)
+	}
+
+	_, _, err = readSFTP(t, func(client *sftp.Client) {
+		putFile(client, "big.txt", strings.Repeat("a", MaxUploadSize+1))
+	})
+	var tooLargeErr *UploadTooLargeError
+	if !errors.As(err, &tooLargeErr) {
+		t.Errorf("ReadSFTP() error = %v, want %T", err, tooLargeErr)
+	}
+
+	_, _, err = readSFTP(t, func(client *sftp.Client) {
+		client.ReadDir(".")
+	})
+	if err == nil {
+		t.Errorf("ReadSFTP() without files succeeded, want an error")
+	}
+}
@@ -0,0 +1,763 @@
+package main
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrSnippetBurned    = errors.New("snippet has been burned")
+	ErrViewLimitReached = errors.New("snippet has reached its view limit")
+	ErrPasswordRequired = errors.New("snippet is password protected")
+	ErrWrongPassword    = errors.New("wrong password")
+	ErrNotOwner         = errors.New("snippet belongs to another key")
+	ErrEncryptedUpdate  = errors.New("encrypted snippets can't be updated")
+)
+
+// MaxOversizeDrain is how much input beyond MaxUploadSize is read (and
+// dropped) to report the size of an oversized upload.
+const MaxOversizeDrain = MaxStreamSize
+
+// SnippetManager creates and reads the snippets shared over ssh and http.
+// Uploads larger than MaxUploadSize are either rejected or truncated. Up to
+// maxRevisions previous revisions of updated snippets are kept.
+type SnippetManager struct {
+	store           Store
+	rejectOversized bool
+	maxRevisions    int
+	watchers        map[string]*snippetWatch
+	keyLocks        map[string]*keyLock
+	lock            sync.Mutex
+}
+
+func NewSnippetManager(store Store, rejectOversized bool, maxRevisions int) *SnippetManager {
+	return &SnippetManager{
+		store:           store,
+		rejectOversized: rejectOversized,
+		maxRevisions:    maxRevisions,
+		watchers:        make(map[string]*snippetWatch),
+		keyLocks:        make(map[string]*keyLock),
+	}
+}
+
+// snippetWatch is shared by everyone watching the same snippet, changedCH
+// is closed and replaced whenever the snippet is updated.
+type snippetWatch struct {
+	changedCH chan struct{}
+	watchers  int
+}
+
+// keyLock serializes the changes to one snippet, it is shared by everyone
+// waiting to change it.
+type keyLock struct {
+	lock    sync.Mutex
+	waiters int
+}
+
+// SnippetMeta describes a snippet, it is stored along with the code in its
+// SnippetRecord.
+type SnippetMeta struct {
+	Lang      string `json:"lang,omitempty"`
+	Name      string `json:"name,omitempty"`
+	Truncated bool   `json:"truncated,omitempty"`
+	Received  int64  `json:"received,omitempty"`
+	Burn      bool   `json:"burn,omitempty"`
+	Views     int    `json:"views,omitempty"`
+	// PasswordHash is the bcrypt hash of the password protecting the snippet.
+	PasswordHash string `json:"passwordHash,omitempty"`
+	// Encrypted snippets can only be decrypted with the secret in their link.
+	Encrypted bool `json:"encrypted,omitempty"`
+	// Owner is the SHA256 fingerprint of the ssh key the snippet was
+	// uploaded with, empty for anonymous uploads.
+	Owner string `json:"owner,omitempty"`
+}
+
+// Protected reports whether the snippet can only be viewed with a password.
+func (m SnippetMeta) Protected() bool {
+	return m.PasswordHash != ""
+}
+
+// CheckPassword returns ErrPasswordRequired or ErrWrongPassword unless
+// password unlocks the snippet. Unprotected snippets take any password.
+func (m SnippetMeta) CheckPassword(password string) error {
+	if !m.Protected() {
+		return nil
+	}
+	if password == "" {
+		return ErrPasswordRequired
+	}
+	err := bcrypt.CompareHashAndPassword([]byte(m.PasswordHash), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return ErrWrongPassword
+	}
+	return err
+}
+
+// CheckOwner returns ErrNotOwner unless the snippet was uploaded with the
+// ssh key of owner. Anonymous snippets have no owner.
+func (m SnippetMeta) CheckOwner(owner string) error {
+	if owner == "" || m.Owner != owner {
+		return ErrNotOwner
+	}
+	return nil
+}
+
+// Limited reports whether every view of the snippet uses up one of a
+// limited number of views.
+func (m SnippetMeta) Limited() bool {
+	return m.Burn || m.Views > 0
+}
+
+type Snippet struct {
+	Key string
+	// ViewsLeft is how many more times a snippet with a view limit can be
+	// viewed after this view.
+	ViewsLeft int
+	SnippetRecord
+}
+
+type SnippetOptions struct {
+	TTL time.Duration
+	// Password is only kept as a hash in the metadata.
+	Password string
+	// Encrypt seals the code with a key that is returned but never stored.
+	Encrypt bool
+	// Files split the code into the files of a multi-file snippet.
+	Files []SnippetFile
+	SnippetMeta
+}
+
+// Create stores code along with its metadata as a SnippetRecord under a new
+// key and bumps the uploaded snippets counter. Encrypted snippets are sealed
+// before they reach the store and the returned secret is needed to read
+// them, see SnippetLink.
+func (m *SnippetManager) Create(ctx context.Context, code []byte, opts SnippetOptions) (key, secret string, err error) {
+	key = GenKey()
+	if !opts.Truncated {
+		// the upload size is only worth keeping to explain a truncation
+		opts.Received = 0
+	}
+	if opts.Password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(opts.Password), bcrypt.DefaultCost)
+		if err != nil {
+			return "", "", err
+		}
+		opts.PasswordHash = string(hash)
+	}
+	if opts.Encrypt {
+		code, secret, err = SealSnippet(code)
+		if err != nil {
+			return "", "", err
+		}
+		opts.Encrypted = true
+	}
+	if opts.Views > 0 {
+		err := m.store.Set(ctx, viewsKey(key), opts.Views, opts.TTL)
+		if err != nil {
+			return "", "", err
+		}
+	}
+
+	now := time.Now().UTC()
+	record := &SnippetRecord{Code: code, CreatedAt: now, Revision: 1, Files: opts.Files, SnippetMeta: opts.SnippetMeta}
+	if opts.TTL > 0 {
+		record.ExpiresAt = now.Add(opts.TTL)
+	}
+	err = m.put(ctx, key, record)
+	if err != nil {
+		return "", "", err
+	}
+	if opts.Owner != "" {
+		err = m.addOwned(ctx, opts.Owner, key)
+		if err != nil {
+			return "", "", err
+		}
+	}
+	_, err = m.store.Incr(ctx, CodeUploadedCountKey)
+	if err != nil {
+		return "", "", err
+	}
+	return key, secret, nil
+}
+
+// SnippetLink builds the link to a snippet page. The secret of an encrypted
+// snippet goes into the fragment, which browsers never send to the server.
+func SnippetLink(host, key, secret string) string {
+	if secret == "" {
+		return fmt.Sprintf("%s/c/%s", host, key)
+	}
+	return fmt.Sprintf("%s/c/%s#%s", host, key, secret)
+}
+
+// View returns the snippet stored under key and counts as it being read:
+// burn after reading snippets are deleted atomically, so only the first
+// viewer gets them and everyone else gets ErrSnippetBurned. Snippets with a
+// view limit count down atomically and return ErrViewLimitReached once the
+// limit is used up. Password protected snippets are only returned, and
+// counted as read, with the right password. Anything that
+// doesn't look like a generated key is reported as not found, so internal
+// keys such as the rate limiter counters can't be read through it.
+func (m *SnippetManager) View(ctx context.Context, key, password string) (*Snippet, error) {
+	return m.ViewChecked(ctx, key, password, nil)
+}
+
+// ViewChecked is View with check run on the snippet once the password is
+// known to be right, before it counts as read. An error from check is
+// returned as it is and leaves the snippet untouched, e.g. a request for a
+// file the snippet doesn't have doesn't use up a view.
+func (m *SnippetManager) ViewChecked(ctx context.Context, key, password string, check func(record *SnippetRecord) error) (*Snippet, error) {
+	if !IsValidKey(key) {
+		return nil, ErrKeyNotFound
+	}
+	record, err := m.get(ctx, key)
+	if errors.Is(err, ErrKeyNotFound) {
+		return nil, m.notFound(ctx, key)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := record.CheckPassword(password); err != nil {
+		return nil, err
+	}
+	if check != nil {
+		if err := check(record); err != nil {
+			return nil, err
+		}
+	}
+	if record.Views > 0 {
+		return m.viewLimited(ctx, key, record)
+	}
+	if !record.Burn {
+		return &Snippet{Key: key, SnippetRecord: *record}, nil
+	}
+
+	raw, err := m.store.GetDel(ctx, key)
+	if errors.Is(err, ErrKeyNotFound) {
+		return nil, m.notFound(ctx, key)
+	}
+	if err != nil {
+		return nil, err
+	}
+	record, err = DecodeSnippetRecord(raw)
+	if err != nil {
+		return nil, err
+	}
+	err = m.store.Set(ctx, burnedKey(key), time.Now().Unix(), MaxTTL)
+	if err != nil {
+		return nil, err
+	}
+	return &Snippet{Key: key, SnippetRecord: *record}, nil
+}
+
+func (m *SnippetManager) viewLimited(ctx context.Context, key string, record *SnippetRecord) (*Snippet, error) {
+	left, err := m.store.Decr(ctx, viewsKey(key))
+	if err != nil {
+		return nil, err
+	}
+	if left < 0 {
+		// Decr creates a missing counter without a TTL, e.g. once it
+		// expired before the snippet. Exhausted and missing counters read
+		// the same, so it's dropped rather than kept forever.
+		err = m.store.Del(ctx, viewsKey(key))
+		if err != nil {
+			return nil, err
+		}
+		return nil, ErrViewLimitReached
+	}
+	if left == 0 {
+		// this was the last view, only the metadata is kept for Status
+		tombstone := *record
+		tombstone.Code = nil
+		if tombstone.ExpiresAt.IsZero() {
+			err = m.store.Del(ctx, key)
+		} else {
+			err = m.put(ctx, key, &tombstone)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &Snippet{Key: key, ViewsLeft: int(left), SnippetRecord: *record}, nil
+}
+
+// notFound tells a burned snippet apart from one that never existed or
+// has expired.
+func (m *SnippetManager) notFound(ctx context.Context, key string) error {
+	_, err := m.store.Get(ctx, burnedKey(key))
+	switch {
+	case err == nil:
+		return ErrSnippetBurned
+	case errors.Is(err, ErrKeyNotFound):
+		return ErrKeyNotFound
+	}
+	return err
+}
+
+// Meta returns the metadata of a snippet without counting it as read.
+func (m *SnippetManager) Meta(ctx context.Context, key string) (SnippetMeta, error) {
+	if !IsValidKey(key) {
+		return SnippetMeta{}, ErrKeyNotFound
+	}
+	record, err := m.get(ctx, key)
+	if err != nil {
+		return SnippetMeta{}, err
+	}
+	return record.SnippetMeta, nil
+}
+
+// addOwned adds a snippet to the index of its owner, which lives as long
+// as the longest lived snippet can.
+func (m *SnippetManager) addOwned(ctx context.Context, owner, key string) error {
+	err := m.store.SAdd(ctx, ownerKey(owner), key)
+	if err != nil {
+		return err
+	}
+	return m.store.Expire(ctx, ownerKey(owner), MaxTTL)
+}
+
+// List returns the snippets uploaded with the ssh key of owner, newest
+// first. Snippets that have expired since are dropped from the index.
+func (m *SnippetManager) List(ctx context.Context, owner string) ([]*Snippet, error) {
+	if owner == "" {
+		return nil, nil
+	}
+	keys, err := m.store.SMembers(ctx, ownerKey(owner))
+	if err != nil {
+		return nil, err
+	}
+	var snippets []*Snippet
+	for _, key := range keys {
+		record, err := m.get(ctx, key)
+		if errors.Is(err, ErrKeyNotFound) {
+			err = m.store.SRem(ctx, ownerKey(owner), key)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if record.Owner != owner {
+			continue
+		}
+		snippet := &Snippet{Key: key, SnippetRecord: *record}
+		if record.Views > 0 {
+			snippet.ViewsLeft, err = m.viewsLeft(ctx, key)
+			if err != nil {
+				return nil, err
+			}
+		}
+		snippets = append(snippets, snippet)
+	}
+	sort.Slice(snippets, func(i, j int) bool {
+		return snippets[i].CreatedAt.After(snippets[j].CreatedAt)
+	})
+	return snippets, nil
+}
+
+// Delete removes a snippet before it expires. Only the ssh key it was
+// uploaded with can delete it, anyone else gets ErrNotOwner.
+func (m *SnippetManager) Delete(ctx context.Context, key, owner string) error {
+	if !IsValidKey(key) {
+		return ErrKeyNotFound
+	}
+	record, err := m.get(ctx, key)
+	if err != nil {
+		return err
+	}
+	if err := record.CheckOwner(owner); err != nil {
+		return err
+	}
+	for _, k := range append([]string{key, viewsKey(key)}, m.revisionKeys(key, record)...) {
+		err = m.store.Del(ctx, k)
+		if err != nil {
+			return err
+		}
+	}
+	return m.store.SRem(ctx, ownerKey(owner), key)
+}
+
+// Update replaces the code of a snippet, keeping its key and thus its
+// link. The language and file name are only replaced when opts sets them,
+// and the expiry time only when opts sets a TTL. Only the owner set in opts
+// can update a snippet, and everyone watching it is notified.
+func (m *SnippetManager) Update(ctx context.Context, key string, code []byte, opts SnippetOptions) (*SnippetRecord, error) {
+	return m.modify(ctx, key, opts.Owner, func(record *SnippetRecord, now time.Time) error {
+		if opts.TTL > 0 {
+			record.ExpiresAt = now.Add(opts.TTL)
+			if record.Views > 0 {
+				err := m.store.Expire(ctx, viewsKey(key), opts.TTL)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		if opts.Lang != "" {
+			record.Lang = opts.Lang
+		}
+		if opts.Name != "" {
+			record.Name = opts.Name
+		}
+		record.Truncated = opts.Truncated
+		record.Received = 0
+		if opts.Truncated {
+			record.Received = opts.Received
+		}
+		record.Code = code
+		record.Chunks = nil
+		record.Files = nil
+		return nil
+	})
+}
+
+// Append adds code to the end of a snippet as a new chunk, which is shown
+// with the time it was appended. Appending more than fits into
+// MaxUploadSize fails with an *AppendTooLargeError and changes nothing.
+func (m *SnippetManager) Append(ctx context.Context, key string, code []byte, owner string) (*SnippetRecord, error) {
+	return m.modify(ctx, key, owner, func(record *SnippetRecord, now time.Time) error {
+		if len(record.Files) > 0 {
+			return ErrFilesAppend
+		}
+		if len(record.Code)+len(code) > MaxUploadSize || record.Truncated {
+			return &AppendTooLargeError{Size: int64(len(record.Code)), Appended: int64(len(code))}
+		}
+		if len(record.Chunks) == 0 {
+			record.Chunks = []SnippetChunk{{At: record.UpdatedAt}}
+			if record.UpdatedAt.IsZero() {
+				record.Chunks[0].At = record.CreatedAt
+			}
+		}
+		record.Chunks = append(record.Chunks, SnippetChunk{Offset: len(record.Code), At: now})
+		record.Code = append(record.Code[:len(record.Code):len(record.Code)], code...)
+		return nil
+	})
+}
+
+// modify changes the code of a snippet owned by owner with change, keeping
+// the previous revision and notifying everyone watching the snippet.
+// Changes to the same snippet are made one at a time, so concurrent
+// appends don't overwrite each other. Like watching, this only holds
+// within this manager.
+func (m *SnippetManager) modify(ctx context.Context, key, owner string, change func(record *SnippetRecord, now time.Time) error) (*SnippetRecord, error) {
+	if !IsValidKey(key) {
+		return nil, ErrKeyNotFound
+	}
+	unlock := m.lockKey(key)
+	defer unlock()
+
+	record, err := m.get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if err := record.CheckOwner(owner); err != nil {
+		return nil, err
+	}
+	// the secret of the link isn't known here, so the code can't be sealed
+	if record.Encrypted {
+		return nil, ErrEncryptedUpdate
+	}
+
+	previous := *record
+	now := time.Now().UTC()
+	err = change(record, now)
+	if err != nil {
+		return nil, err
+	}
+	record.UpdatedAt = now
+	record.Revision = max(record.Revision, 1) + 1
+
+	err = m.keepRevision(ctx, key, &previous, record)
+	if err != nil {
+		return nil, err
+	}
+	err = m.put(ctx, key, record)
+	if err != nil {
+		return nil, err
+	}
+	m.notify(key)
+	return record, nil
+}
+
+// Watch returns a channel that is closed the next time the snippet is
+// updated. Call stop once done watching. Only updates made through this
+// manager are seen, like tunnels they don't cross instances.
+func (m *SnippetManager) Watch(key string) (changed <-chan struct{}, stop func()) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	watch, ok := m.watchers[key]
+	if !ok {
+		watch = &snippetWatch{changedCH: make(chan struct{})}
+		m.watchers[key] = watch
+	}
+	watch.watchers++
+
+	stopped := false
+	return watch.changedCH, func() {
+		m.lock.Lock()
+		defer m.lock.Unlock()
+		if stopped {
+			return
+		}
+		stopped = true
+		watch.watchers--
+		if watch.watchers == 0 && m.watchers[key] == watch {
+			delete(m.watchers, key)
+		}
+	}
+}
+
+// lockKey waits until no one else is changing the snippet, call unlock
+// once done.
+func (m *SnippetManager) lockKey(key string) (unlock func()) {
+	m.lock.Lock()
+	l, ok := m.keyLocks[key]
+	if !ok {
+		l = &keyLock{}
+		m.keyLocks[key] = l
+	}
+	l.waiters++
+	m.lock.Unlock()
+
+	l.lock.Lock()
+	return func() {
+		l.lock.Unlock()
+		m.lock.Lock()
+		defer m.lock.Unlock()
+		l.waiters--
+		if l.waiters == 0 {
+			delete(m.keyLocks, key)
+		}
+	}
+}
+
+func (m *SnippetManager) notify(key string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	watch, ok := m.watchers[key]
+	if !ok {
+		return
+	}
+	close(watch.changedCH)
+	watch.changedCH = make(chan struct{})
+}
+
+func (m *SnippetManager) viewsLeft(ctx context.Context, key string) (int, error) {
+	raw, err := m.store.Get(ctx, viewsKey(key))
+	if errors.Is(err, ErrKeyNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	left, err := strconv.Atoi(string(raw))
+	if err != nil {
+		return 0, err
+	}
+	return max(left, 0), nil
+}
+
+func (m *SnippetManager) get(ctx context.Context, key string) (*SnippetRecord, error) {
+	raw, err := m.store.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return DecodeSnippetRecord(raw)
+}
+
+// put writes a record, keeping the expiry time it was created with.
+func (m *SnippetManager) put(ctx context.Context, key string, record *SnippetRecord) error {
+	raw, err := EncodeSnippetRecord(record)
+	if err != nil {
+		return err
+	}
+	return m.store.Set(ctx, key, raw, record.TTL())
+}
+
+type SnippetStatus struct {
+	Key        string     `json:"key"`
+	Burn       bool       `json:"burn"`
+	Protected  bool       `json:"protected"`
+	Views      int        `json:"views,omitempty"`
+	ViewsLeft  *int       `json:"viewsLeft,omitempty"`
+	Consumed   bool       `json:"consumed"`
+	ConsumedAt *time.Time `json:"consumedAt,omitempty"`
+	CreatedAt  *time.Time `json:"createdAt,omitempty"`
+	ExpiresAt  *time.Time `json:"expiresAt,omitempty"`
+}
+
+// Status tells the uploader what happened to a snippet without reading it.
+func (m *SnippetManager) Status(ctx context.Context, key string) (*SnippetStatus, error) {
+	if !IsValidKey(key) {
+		return nil, ErrKeyNotFound
+	}
+	burnedAt, err := m.store.Get(ctx, burnedKey(key))
+	switch {
+	case err == nil:
+		seconds, err := strconv.ParseInt(string(burnedAt), 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		consumedAt := time.Unix(seconds, 0).UTC()
+		return &SnippetStatus{Key: key, Burn: true, Consumed: true, ConsumedAt: &consumedAt}, nil
+	case !errors.Is(err, ErrKeyNotFound):
+		return nil, err
+	}
+
+	record, err := m.get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	status := &SnippetStatus{Key: key, Burn: record.Burn, Protected: record.Protected(), Views: record.Views}
+	if !record.CreatedAt.IsZero() {
+		status.CreatedAt = &record.CreatedAt
+		status.ExpiresAt = &record.ExpiresAt
+	}
+	if record.Views > 0 {
+		left, err := m.viewsLeft(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		status.ViewsLeft = &left
+		status.Consumed = left == 0
+	}
+	return status, nil
+}
+
+// ownerKey is the set of snippet keys uploaded with an ssh key.
+func ownerKey(owner string) string {
+	return fmt.Sprintf("owner:%s", owner)
+}
+
+func burnedKey(key string) string {
+	return fmt.Sprintf("burned:%s", key)
+}
+
+func viewsKey(key string) string {
+	return fmt.Sprintf("views:%s", key)
+}
+
+type Upload struct {
+	Code      []byte
+	Received  int64
+	Truncated bool
+}
+
+type UploadTooLargeError struct {
+	Received int64
+}
+
+func (e *UploadTooLargeError) Error() string {
+	return fmt.Sprintf("received %s, which is more than the %s limit", FormatReceived(e.Received), FormatSize(MaxUploadSize))
+}
+
+// AppendTooLargeError is returned when appending would grow a snippet
+// beyond MaxUploadSize.
+type AppendTooLargeError struct {
+	Size     int64
+	Appended int64
+}
+
+func (e *AppendTooLargeError) Error() string {
+	return fmt.Sprintf("appending %s to the %s snippet would exceed the %s limit",
+		FormatReceived(e.Appended), FormatSize(e.Size), FormatSize(MaxUploadSize))
+}
+
+// ReadUpload reads up to MaxUploadSize bytes of an upload. Input beyond that
+// is counted, then the upload is truncated or rejected with an
+// *UploadTooLargeError depending on the configuration.
+func (m *SnippetManager) ReadUpload(r io.Reader) (*Upload, error) {
+	buf := make([]byte, MaxUploadSize)
+	n, err := io.ReadFull(r, buf)
+	switch {
+	case err == io.EOF || err == io.ErrUnexpectedEOF:
+		return &Upload{Code: buf[:n], Received: int64(n)}, nil
+	case err != nil:
+		return nil, err
+	}
+
+	extra, err := io.Copy(io.Discard, io.LimitReader(r, MaxOversizeDrain))
+	if err != nil {
+		return nil, err
+	}
+	upload := &Upload{
+		Code:      buf,
+		Received:  int64(n) + extra,
+		Truncated: extra > 0,
+	}
+	if upload.Truncated && m.rejectOversized {
+		return nil, &UploadTooLargeError{Received: upload.Received}
+	}
+	return upload, nil
+}
+
+func FormatSize(size int64) string {
+	switch {
+	case size >= 1024*1024:
+		return fmt.Sprintf("%.1f MB", float64(size)/1024/1024)
+	case size >= 1024:
+		return fmt.Sprintf("%.1f KB", float64(size)/1024)
+	}
+	return fmt.Sprintf("%d B", size)
+}
+
+// FormatReceived formats the size of an upload, which is only counted up to
+// MaxOversizeDrain bytes beyond the limit.
+func FormatReceived(received int64) string {
+	if received >= MaxUploadSize+MaxOversizeDrain {
+		return "more than " + FormatSize(received)
+	}
+	return FormatSize(received)
+}
+
+// FormatDuration formats how long a snippet has left to live, rounded down
+// to the largest unit, e.g. 3h12m or 45s.
+func FormatDuration(d time.Duration) string {
+	switch {
+	case d >= time.Hour:
+		return fmt.Sprintf("%dh%02dm", int(d.Hours()), int(d.Minutes())%60)
+	case d >= time.Minute:
+		return fmt.Sprintf("%dm%02ds", int(d.Minutes()), int(d.Seconds())%60)
+	}
+	return fmt.Sprintf("%ds", max(int(d.Seconds()), 0))
+}
+
+// ClampTTL keeps a user provided ttl within MinTTL and MaxTTL.
+func ClampTTL(ttl time.Duration) time.Duration {
+	if ttl < MinTTL {
+		return MinTTL
+	}
+	if ttl > MaxTTL {
+		return MaxTTL
+	}
+	return ttl
+}
+
+func IsValidKey(key string) bool {
+	if len(key) != KeyLength {
+		return false
+	}
+	_, err := hex.DecodeString(key + "0")
+	return err == nil && strings.ToLower(key) == key
+}
+
+func GenKey() string {
+	id := uuid.New()
+	h := sha1.New()
+	h.Write([]byte(id.String()))
+	return hex.EncodeToString(h.Sum(nil))[:KeyLength]
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"slices"
+	"strings"
+	"time"
+)
+
+// MaxFiles is the number of files a snippet can hold.
+const MaxFiles = 100
+
+var (
+	ErrInvalidArchive = errors.New("expected a tar archive")
+	ErrFilesAppend    = errors.New("can't append to a snippet with several files")
+	ErrFileNotFound   = errors.New("snippet has no such file")
+)
+
+// SnippetFile marks where a file of a multi-file snippet starts in its
+// code, which holds the files one after another.
+type SnippetFile struct {
+	Path   string `json:"path"`
+	Offset int    `json:"offset"`
+}
+
+// ReadSnippetUpload reads the upload of a new snippet with the given
+// options. With files it must be a tar archive, otherwise tar archives are
+// recognized and unpacked into a multi-file snippet, unless the upload is
+// encrypted or was truncated.
+func (m *SnippetManager) ReadSnippetUpload(r io.Reader, opts Options) (*Upload, []SnippetFile, error) {
+	if opts.Files {
+		code, files, err := m.ReadFiles(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		return &Upload{Code: code, Received: int64(len(code))}, files, nil
+	}
+
+	upload, err := m.ReadUpload(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	if opts.Encrypt || upload.Truncated || !IsTarArchive(upload.Code) {
+		return upload, nil, nil
+	}
+	code, files, err := m.ReadFiles(bytes.NewReader(upload.Code))
+	if err != nil {
+		// not an archive after all, it is kept as it is
+		return upload, nil, nil
+	}
+	upload.Code, upload.Received = code, int64(len(code))
+	return upload, files, nil
+}
+
+// ReadFiles unpacks a tar archive into the code of a multi-file snippet.
+// Directories, links and the like are skipped. Archives holding more than
+// MaxUploadSize bytes of files are rejected with an *UploadTooLargeError,
+// there is no telling which files to keep.
+func (m *SnippetManager) ReadFiles(r io.Reader) ([]byte, []SnippetFile, error) {
+	// the files are limited on their own, this bounds headers and padding
+	tr := tar.NewReader(io.LimitReader(r, MaxStreamSize))
+	var code []byte
+	var files []SnippetFile
+	seen := make(map[string]bool)
+
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+		}
+		// macOS tar adds the extended attributes of every file as ._name
+		if !header.FileInfo().Mode().IsRegular() || strings.HasPrefix(path.Base(header.Name), "._") {
+			continue
+		}
+
+		filePath, err := ParseFilePath(header.Name)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid file %q: %w", header.Name, err)
+		}
+		if seen[filePath] {
+			return nil, nil, fmt.Errorf("file %q is in the archive more than once", filePath)
+		}
+		seen[filePath] = true
+		if len(files) == MaxFiles {
+			return nil, nil, fmt.Errorf("the archive holds more than %d files", MaxFiles)
+		}
+		if header.Size > int64(MaxUploadSize-len(code)) {
+			return nil, nil, &UploadTooLargeError{Received: int64(len(code)) + header.Size}
+		}
+
+		files = append(files, SnippetFile{Path: filePath, Offset: len(code)})
+		content := make([]byte, header.Size)
+		_, err = io.ReadFull(tr, content)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+		}
+		code = append(code, content...)
+	}
+	if len(files) == 0 {
+		return nil, nil, fmt.Errorf("%w with at least one file", ErrInvalidArchive)
+	}
+	return code, files, nil
+}
+
+// IsTarArchive reports whether b starts with a ustar header, which is what
+// tar writes by default.
+func IsTarArchive(b []byte) bool {
+	return len(b) >= 512 && bytes.HasPrefix(b[257:], []byte("ustar"))
+}
+
+// reservedFileDirs are the first segments of paths below a snippet page that
+// belong to the page itself, see handleCodePage.
+var reservedFileDirs = []string{"events", "v", "diff"}
+
+// ParseFilePath turns the name of a file in an archive into a relative
+// path that stays below the snippet. Paths the snippet page uses for its
+// events, revisions and diffs are refused, the file couldn't be fetched.
+func ParseFilePath(name string) (string, error) {
+	if strings.ContainsFunc(name, func(r rune) bool { return r < 0x20 || r == 0x7f }) {
+		return "", fmt.Errorf("path contains control characters")
+	}
+	filePath := strings.TrimPrefix(path.Clean("/"+name), "/")
+	if filePath == "" {
+		return "", fmt.Errorf("expected a file path")
+	}
+	if len(filePath) > MaxNameLength {
+		return "", fmt.Errorf("path is longer than %d characters", MaxNameLength)
+	}
+	dir, _, _ := strings.Cut(filePath, "/")
+	if slices.Contains(reservedFileDirs, dir) {
+		return "", fmt.Errorf("path %q is reserved, %s/ belongs to the snippet page", filePath, dir)
+	}
+	return filePath, nil
+}
+
+// SplitFiles returns the code of every file of a multi-file snippet, in
+// the order they were in the archive.
+func (r *SnippetRecord) SplitFiles() [][]byte {
+	offsets := make([]int, len(r.Files))
+	for i, file := range r.Files {
+		offsets[i] = file.Offset
+	}
+	return splitCode(r.Code, offsets)
+}
+
+// File returns the code of the file at filePath.
+func (r *SnippetRecord) File(filePath string) ([]byte, bool) {
+	for i, code := range r.SplitFiles() {
+		if r.Files[i].Path == filePath {
+			return code, true
+		}
+	}
+	return nil, false
+}
+
+// JoinedCode returns the code of a snippet as plain text: the files of a
+// multi-file snippet are separated by their path the way head does it.
+func (r *SnippetRecord) JoinedCode() []byte {
+	if len(r.Files) == 0 {
+		return r.Code
+	}
+	var b bytes.Buffer
+	for i, code := range r.SplitFiles() {
+		if i > 0 {
+			b.WriteByte('\n')
+		}
+		fmt.Fprintf(&b, "==> %s <==\n", r.Files[i].Path)
+		b.Write(code)
+		if len(code) > 0 && code[len(code)-1] != '\n' {
+			b.WriteByte('\n')
+		}
+	}
+	return b.Bytes()
+}
+
+// splitCode cuts code at the given offsets, the first of which is where
+// the first part starts.
+func splitCode(code []byte, offsets []int) [][]byte {
+	parts := make([][]byte, len(offsets))
+	for i, offset := range offsets {
+		end := len(code)
+		if i+1 < len(offsets) {
+			end = min(offsets[i+1], end)
+		}
+		parts[i] = code[min(offset, end):end]
+	}
+	return parts
+}
+
+// WriteZip writes the files of a multi-file snippet to w as a zip archive,
+// with the paths they were uploaded with.
+func (r *SnippetRecord) WriteZip(w io.Writer) error {
+	zw := zip.NewWriter(w)
+	for i, code := range r.SplitFiles() {
+		fw, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     r.Files[i].Path,
+			Method:   zip.Deflate,
+			Modified: r.modified(),
+		})
+		if err != nil {
+			return err
+		}
+		_, err = fw.Write(code)
+		if err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// WriteTarGz writes the files of a multi-file snippet to w as a gzipped tar
+// archive, with the paths they were uploaded with.
+func (r *SnippetRecord) WriteTarGz(w io.Writer) error {
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+	for i, code := range r.SplitFiles() {
+		err := tw.WriteHeader(&tar.Header{
+			Name:    r.Files[i].Path,
+			Mode:    0o644,
+			Size:    int64(len(code)),
+			ModTime: r.modified(),
+		})
+		if err != nil {
+			return err
+		}
+		_, err = tw.Write(code)
+		if err != nil {
+			return err
+		}
+	}
+	err := tw.Close()
+	if err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+// modified returns when the code was last changed.
+func (r *SnippetRecord) modified() time.Time {
+	if r.UpdatedAt.IsZero() {
+		return r.CreatedAt
+	}
+	return r.UpdatedAt
+}
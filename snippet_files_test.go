@@ -0,0 +1,100 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// tarFiles writes a tar archive of the given path and content pairs.
+func tarFiles(t *testing.T, files ...string) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	tw := tar.NewWriter(&b)
+	for i := 0; i < len(files); i += 2 {
+		err := tw.WriteHeader(&tar.Header{Name: files[i], Mode: 0o644, Size: int64(len(files[i+1]))})
+		if err != nil {
+			t.Fatalf("WriteHeader() error = %v", err)
+		}
+		if _, err := tw.Write([]byte(files[i+1])); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	return b.Bytes()
+}
+
+func TestSnippetManager_ReadFiles(t *testing.T) {
+	m := NewSnippetManager(NewMemoryStore(), false, DefaultMaxRevisions)
+	archive := tarFiles(t, "./a.go", "package a\n", "cmd/../b.go", "package b\n", "._a.go", "xattrs")
+	if !IsTarArchive(archive) {
+		t.Fatalf("IsTarArchive() = false, want true")
+	}
+
+	code, files, err := m.ReadFiles(bytes.NewReader(archive))
+	if err != nil {
+		t.Fatalf("ReadFiles() error = %v", err)
+	}
+	record := &SnippetRecord{Code: code, Files: files}
+	if got, _ := record.File("b.go"); string(got) != "package b\n" {
+		t.Errorf("File(b.go) = %q, want %q", got, "package b\n")
+	}
+	if _, ok := record.File("._a.go"); ok {
+		t.Errorf("File(._a.go) found, want it skipped")
+	}
+	want := "==> a.go <==\npackage a\n\n==> b.go <==\npackage b\n"
+	if got := string(record.JoinedCode()); got != want {
+		t.Errorf("JoinedCode() = %q, want %q", got, want)
+	}
+
+	_, _, err = m.ReadFiles(bytes.NewReader(tarFiles(t, "a.go", "a", "./a.go", "b")))
+	if err == nil {
+		t.Errorf("ReadFiles() of duplicate files succeeded, want an error")
+	}
+	_, _, err = m.ReadFiles(bytes.NewReader(tarFiles(t, "a.txt", strings.Repeat("a", MaxUploadSize), "b.txt", "b")))
+	var tooLargeErr *UploadTooLargeError
+	if !errors.As(err, &tooLargeErr) {
+		t.Errorf("ReadFiles() error = %v, want %T", err, tooLargeErr)
+	}
+	_, _, err = m.ReadFiles(strings.NewReader("package main\n"))
+	if !errors.Is(err, ErrInvalidArchive) {
+		t.Errorf("ReadFiles() error = %v, want %v", err, ErrInvalidArchive)
+	}
+}
+
+func TestParseFilePath(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    string
+		wantErr bool
+	}{
+		{name: "main.go", want: "main.go"},
+		{name: "./cmd/main.go", want: "cmd/main.go"},
+		{name: "../../etc/passwd", want: "etc/passwd"},
+		{name: "/abs/path.go", want: "abs/path.go"},
+		{name: "./", wantErr: true},
+		{name: "events", wantErr: true},
+		{name: "v/2", wantErr: true},
+		{name: "./diff/1..2", wantErr: true},
+		{name: "events/main.go", wantErr: true},
+		{name: "cmd/v/main.go", want: "cmd/v/main.go"},
+		{name: "events.go", want: "events.go"},
+		{name: "bad\nname.go", wantErr: true},
+		{name: strings.Repeat("a", MaxNameLength+1), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseFilePath(tt.name)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseFilePath() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseFilePath() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// SnippetRecordVersion is the version of the record format written by
+// EncodeSnippetRecord. Records of other versions are rejected when they are
+// read, so a rollback can't misread them.
+const SnippetRecordVersion = 1
+
+// snippetRecordMagic starts every encoded record. Values without it were
+// stored before records existed and are plain code.
+var snippetRecordMagic = []byte("\x00csr")
+
+var ErrUnknownRecordVersion = errors.New("unknown snippet record version")
+
+// SnippetRecord is everything stored under the key of a snippet. It is
+// encoded as the magic, the version byte, the length of the JSON encoded
+// header as uvarint, the header and finally the code as it is, so the code
+// doesn't have to be escaped.
+type SnippetRecord struct {
+	Code      []byte    `json:"-"`
+	CreatedAt time.Time `json:"createdAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	// UpdatedAt is when the code was last replaced, zero if it never was.
+	UpdatedAt time.Time `json:"updatedAt"`
+	// Revision counts the versions of the code, starting at 1. Records
+	// written before updates existed have none.
+	Revision int `json:"revision,omitempty"`
+	// Chunks mark where appended code starts, the first one covers the
+	// code the snippet had before. Snippets never appended to have none.
+	Chunks []SnippetChunk `json:"chunks,omitempty"`
+	// Files are set for snippets uploaded as an archive of several files.
+	Files []SnippetFile `json:"files,omitempty"`
+	SnippetMeta
+}
+
+type SnippetChunk struct {
+	// Offset is where the chunk starts in the code.
+	Offset int       `json:"offset"`
+	At     time.Time `json:"at"`
+}
+
+// SplitChunks returns the code of every chunk, or the whole code as a single
+// chunk for snippets never appended to.
+func (r *SnippetRecord) SplitChunks() [][]byte {
+	if len(r.Chunks) == 0 {
+		return [][]byte{r.Code}
+	}
+	offsets := make([]int, len(r.Chunks))
+	for i, chunk := range r.Chunks {
+		offsets[i] = chunk.Offset
+	}
+	return splitCode(r.Code, offsets)
+}
+
+// TTL returns how long the snippet has left to live, or 0 for records
+// stored without an expiry time.
+func (r *SnippetRecord) TTL() time.Duration {
+	if r.ExpiresAt.IsZero() {
+		return 0
+	}
+	return max(time.Until(r.ExpiresAt), time.Second)
+}
+
+func EncodeSnippetRecord(r *SnippetRecord) ([]byte, error) {
+	header, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+	raw := make([]byte, 0, len(snippetRecordMagic)+1+binary.MaxVarintLen64+len(header)+len(r.Code))
+	raw = append(raw, snippetRecordMagic...)
+	raw = append(raw, SnippetRecordVersion)
+	raw = binary.AppendUvarint(raw, uint64(len(header)))
+	raw = append(raw, header...)
+	return append(raw, r.Code...), nil
+}
+
+// DecodeSnippetRecord reads a record written by EncodeSnippetRecord. Plain
+// values stored before records existed are returned as a record holding
+// just the code.
+func DecodeSnippetRecord(raw []byte) (r *SnippetRecord, err error) {
+	if !bytes.HasPrefix(raw, snippetRecordMagic) {
+		return &SnippetRecord{Code: raw}, nil
+	}
+	raw = raw[len(snippetRecordMagic):]
+	if len(raw) == 0 || raw[0] != SnippetRecordVersion {
+		return nil, ErrUnknownRecordVersion
+	}
+	raw = raw[1:]
+
+	headerLen, n := binary.Uvarint(raw)
+	if n <= 0 || headerLen > uint64(len(raw)-n) {
+		return nil, fmt.Errorf("snippet record header is corrupt")
+	}
+	raw = raw[n:]
+
+	r = &SnippetRecord{}
+	err = json.Unmarshal(raw[:headerLen], r)
+	if err != nil {
+		return nil, err
+	}
+	r.Code = raw[headerLen:]
+	return r, nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSnippetRecord_Codec(t *testing.T) {
+	createdAt := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		record  *SnippetRecord
+		raw     []byte
+		want    *SnippetRecord
+		wantErr error
+	}{
+		{
+			name: "record",
+			record: &SnippetRecord{
+				Code:        []byte("{\"looks\": \"like a header\"}"),
+				CreatedAt:   createdAt,
+				ExpiresAt:   createdAt.Add(MaxTTL),
+				SnippetMeta: SnippetMeta{Lang: "json", Name: "data.json", Views: 3},
+			},
+			want: &SnippetRecord{
+				Code:        []byte("{\"looks\": \"like a header\"}"),
+				CreatedAt:   createdAt,
+				ExpiresAt:   createdAt.Add(MaxTTL),
+				SnippetMeta: SnippetMeta{Lang: "json", Name: "data.json", Views: 3},
+			},
+		},
+		{
+			name:   "record without code",
+			record: &SnippetRecord{CreatedAt: createdAt},
+			want:   &SnippetRecord{Code: []byte{}, CreatedAt: createdAt},
+		},
+		{
+			name: "plain value",
+			raw:  []byte("package main"),
+			want: &SnippetRecord{Code: []byte("package main")},
+		},
+		{
+			name:    "unknown version",
+			raw:     []byte("\x00csr\x09{}"),
+			wantErr: ErrUnknownRecordVersion,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw := tt.raw
+			if tt.record != nil {
+				var err error
+				raw, err = EncodeSnippetRecord(tt.record)
+				if err != nil {
+					t.Fatalf("EncodeSnippetRecord() error = %v", err)
+				}
+			}
+
+			got, err := DecodeSnippetRecord(raw)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("DecodeSnippetRecord() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("DecodeSnippetRecord() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("DecodeSnippetRecord() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
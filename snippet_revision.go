@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// DefaultMaxRevisions is how many previous revisions of a snippet are kept
+// unless MAX_REVISIONS says otherwise.
+const DefaultMaxRevisions = 10
+
+var ErrRevisionNotFound = errors.New("snippet revision not found")
+
+// keepRevision stores the record an update replaces under a key of its own,
+// living as long as the updated snippet. The revision that falls out of the
+// history is deleted. Snippets with a view limit keep no history, reading it
+// would get around the limit.
+func (m *SnippetManager) keepRevision(ctx context.Context, key string, previous, record *SnippetRecord) error {
+	if m.maxRevisions <= 0 || previous.Limited() {
+		return nil
+	}
+	previous.Revision = max(previous.Revision, 1)
+	raw, err := EncodeSnippetRecord(previous)
+	if err != nil {
+		return err
+	}
+	err = m.store.Set(ctx, revisionKey(key, previous.Revision), raw, record.TTL())
+	if err != nil {
+		return err
+	}
+	if dropped := previous.Revision - m.maxRevisions; dropped >= 1 {
+		err = m.store.Del(ctx, revisionKey(key, dropped))
+		if err != nil {
+			return err
+		}
+	}
+
+	if record.ExpiresAt.Equal(previous.ExpiresAt) {
+		return nil
+	}
+	for _, k := range m.revisionKeys(key, previous) {
+		err = m.store.Expire(ctx, k, record.TTL())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Revisions returns the given revisions of a snippet along with the number
+// of its latest revision. Like View it needs the password of protected
+// snippets, but it never counts as a view: snippets with a view limit, and
+// encrypted ones, have no revisions to read.
+func (m *SnippetManager) Revisions(ctx context.Context, key, password string, revisions ...int) ([]*Snippet, int, error) {
+	if !IsValidKey(key) {
+		return nil, 0, ErrKeyNotFound
+	}
+	record, err := m.get(ctx, key)
+	if errors.Is(err, ErrKeyNotFound) {
+		return nil, 0, m.notFound(ctx, key)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	if err := record.CheckPassword(password); err != nil {
+		return nil, 0, err
+	}
+	if record.Limited() || record.Encrypted {
+		return nil, 0, ErrRevisionNotFound
+	}
+
+	latest := max(record.Revision, 1)
+	snippets := make([]*Snippet, 0, len(revisions))
+	for _, n := range revisions {
+		if n == latest {
+			snippets = append(snippets, &Snippet{Key: key, SnippetRecord: *record})
+			continue
+		}
+		if n < 1 || n > latest {
+			return nil, 0, ErrRevisionNotFound
+		}
+		raw, err := m.store.Get(ctx, revisionKey(key, n))
+		if errors.Is(err, ErrKeyNotFound) {
+			return nil, 0, ErrRevisionNotFound
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		revision, err := DecodeSnippetRecord(raw)
+		if err != nil {
+			return nil, 0, err
+		}
+		snippets = append(snippets, &Snippet{Key: key, SnippetRecord: *revision})
+	}
+	return snippets, latest, nil
+}
+
+// revisionKeys returns the keys of the previous revisions that may still
+// be kept for record.
+func (m *SnippetManager) revisionKeys(key string, record *SnippetRecord) []string {
+	var keys []string
+	latest := max(record.Revision, 1)
+	for n := max(latest-m.maxRevisions, 1); n < latest; n++ {
+		keys = append(keys, revisionKey(key, n))
+	}
+	return keys
+}
+
+func revisionKey(key string, revision int) string {
+	return fmt.Sprintf("rev:%s:%d", key, revision)
+}
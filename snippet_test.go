@@ -0,0 +1,320 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestIsValidKey(t *testing.T) {
+	tests := []struct {
+		key  string
+		want bool
+	}{
+		{key: GenKey(), want: true},
+		{key: "abc1234", want: true},
+		{key: "ABC1234", want: false},
+		{key: "abc123", want: false},
+		{key: "code_uploaded_count", want: false},
+		{key: "rate_limiter:127.0.0.1", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			if got := IsValidKey(tt.key); got != tt.want {
+				t.Errorf("IsValidKey(%q) = %v, want %v", tt.key, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSnippetManager_ReadUpload(t *testing.T) {
+	tests := []struct {
+		name            string
+		size            int
+		rejectOversized bool
+		wantStored      int
+		wantTruncated   bool
+		wantErr         bool
+	}{
+		{name: "small upload", size: 42, wantStored: 42},
+		{name: "exactly the limit", size: MaxUploadSize, wantStored: MaxUploadSize},
+		{name: "truncated", size: MaxUploadSize + 10, wantStored: MaxUploadSize, wantTruncated: true},
+		{name: "rejected", size: MaxUploadSize + 10, rejectOversized: true, wantErr: true},
+		{name: "limit with reject", size: MaxUploadSize, rejectOversized: true, wantStored: MaxUploadSize},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewSnippetManager(NewMemoryStore(), tt.rejectOversized, DefaultMaxRevisions)
+			upload, err := m.ReadUpload(strings.NewReader(strings.Repeat("a", tt.size)))
+			if tt.wantErr {
+				var tooLargeErr *UploadTooLargeError
+				if !errors.As(err, &tooLargeErr) {
+					t.Fatalf("ReadUpload() error = %v, want %T", err, tooLargeErr)
+				}
+				if tooLargeErr.Received != int64(tt.size) {
+					t.Errorf("Received = %d, want %d", tooLargeErr.Received, tt.size)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ReadUpload() error = %v", err)
+			}
+			if len(upload.Code) != tt.wantStored {
+				t.Errorf("len(Code) = %d, want %d", len(upload.Code), tt.wantStored)
+			}
+			if upload.Truncated != tt.wantTruncated {
+				t.Errorf("Truncated = %v, want %v", upload.Truncated, tt.wantTruncated)
+			}
+			if upload.Received != int64(tt.size) {
+				t.Errorf("Received = %d, want %d", upload.Received, tt.size)
+			}
+		})
+	}
+}
+
+func TestSnippetManager_ListDelete(t *testing.T) {
+	ctx := context.Background()
+	m := NewSnippetManager(NewMemoryStore(), false, DefaultMaxRevisions)
+
+	create := func(owner string) string {
+		t.Helper()
+		key, _, err := m.Create(ctx, []byte("package main"), SnippetOptions{TTL: time.Minute, SnippetMeta: SnippetMeta{Owner: owner}})
+		if err != nil {
+			t.Fatalf("Create() error = %v", err)
+		}
+		return key
+	}
+	first := create("SHA256:alice")
+	time.Sleep(time.Millisecond)
+	second := create("SHA256:alice")
+	other := create("SHA256:bob")
+	create("")
+
+	snippets, err := m.List(ctx, "SHA256:alice")
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(snippets) != 2 || snippets[0].Key != second || snippets[1].Key != first {
+		t.Fatalf("List() returned %d snippets, want %s and %s newest first", len(snippets), second, first)
+	}
+
+	if err := m.Delete(ctx, other, "SHA256:alice"); !errors.Is(err, ErrNotOwner) {
+		t.Errorf("Delete() of someone else's snippet error = %v, want %v", err, ErrNotOwner)
+	}
+	if err := m.Delete(ctx, first, ""); !errors.Is(err, ErrNotOwner) {
+		t.Errorf("Delete() without a key error = %v, want %v", err, ErrNotOwner)
+	}
+	if err := m.Delete(ctx, first, "SHA256:alice"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, err := m.View(ctx, first, ""); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("View() of a deleted snippet error = %v, want %v", err, ErrKeyNotFound)
+	}
+
+	snippets, err = m.List(ctx, "SHA256:alice")
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(snippets) != 1 || snippets[0].Key != second {
+		t.Errorf("List() after Delete() returned %d snippets, want %s", len(snippets), second)
+	}
+}
+
+func TestSnippetManager_Update(t *testing.T) {
+	ctx := context.Background()
+	m := NewSnippetManager(NewMemoryStore(), false, DefaultMaxRevisions)
+
+	key, _, err := m.Create(ctx, []byte("typo"), SnippetOptions{TTL: time.Hour, SnippetMeta: SnippetMeta{Lang: "go", Owner: "SHA256:alice"}})
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	created, err := m.View(ctx, key, "")
+	if err != nil {
+		t.Fatalf("View() error = %v", err)
+	}
+
+	changed, stop := m.Watch(key)
+	defer stop()
+
+	if _, err := m.Update(ctx, key, []byte("fixed"), SnippetOptions{SnippetMeta: SnippetMeta{Owner: "SHA256:bob"}}); !errors.Is(err, ErrNotOwner) {
+		t.Errorf("Update() by someone else error = %v, want %v", err, ErrNotOwner)
+	}
+	if _, err := m.Update(ctx, key, []byte("fixed"), SnippetOptions{SnippetMeta: SnippetMeta{Owner: "SHA256:alice"}}); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	select {
+	case <-changed:
+	default:
+		t.Error("Update() didn't notify the watcher")
+	}
+
+	snippet, err := m.View(ctx, key, "")
+	if err != nil {
+		t.Fatalf("View() error = %v", err)
+	}
+	if string(snippet.Code) != "fixed" || snippet.Lang != "go" || snippet.Revision != 2 {
+		t.Errorf("View() = %q in %q at revision %d, want %q in go at revision 2", snippet.Code, snippet.Lang, snippet.Revision, "fixed")
+	}
+	if !snippet.ExpiresAt.Equal(created.ExpiresAt) {
+		t.Errorf("ExpiresAt = %v, want it kept at %v", snippet.ExpiresAt, created.ExpiresAt)
+	}
+}
+
+func TestSnippetManager_Revisions(t *testing.T) {
+	ctx := context.Background()
+	m := NewSnippetManager(NewMemoryStore(), false, 2)
+
+	key, _, err := m.Create(ctx, []byte("v1"), SnippetOptions{TTL: time.Hour, SnippetMeta: SnippetMeta{Owner: "SHA256:alice"}})
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	for _, code := range []string{"v2", "v3", "v4"} {
+		_, err := m.Update(ctx, key, []byte(code), SnippetOptions{SnippetMeta: SnippetMeta{Owner: "SHA256:alice"}})
+		if err != nil {
+			t.Fatalf("Update() error = %v", err)
+		}
+	}
+
+	snippets, latest, err := m.Revisions(ctx, key, "", 2, 4)
+	if err != nil {
+		t.Fatalf("Revisions() error = %v", err)
+	}
+	if latest != 4 || string(snippets[0].Code) != "v2" || string(snippets[1].Code) != "v4" {
+		t.Errorf("Revisions() = %q, %q with latest %d, want v2, v4 with latest 4", snippets[0].Code, snippets[1].Code, latest)
+	}
+
+	// only the last 2 previous revisions are kept
+	for _, n := range []int{0, 1, 5} {
+		if _, _, err := m.Revisions(ctx, key, "", n); !errors.Is(err, ErrRevisionNotFound) {
+			t.Errorf("Revisions(%d) error = %v, want %v", n, err, ErrRevisionNotFound)
+		}
+	}
+}
+
+func TestSnippetManager_Append(t *testing.T) {
+	ctx := context.Background()
+	m := NewSnippetManager(NewMemoryStore(), false, DefaultMaxRevisions)
+
+	key, _, err := m.Create(ctx, []byte("$ uname\n"), SnippetOptions{TTL: time.Hour, SnippetMeta: SnippetMeta{Owner: "SHA256:alice"}})
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if _, err := m.Append(ctx, key, []byte("Linux\n"), "SHA256:bob"); !errors.Is(err, ErrNotOwner) {
+		t.Errorf("Append() by someone else error = %v, want %v", err, ErrNotOwner)
+	}
+	for _, code := range []string{"$ uptime\n", "up 3 days\n"} {
+		if _, err := m.Append(ctx, key, []byte(code), "SHA256:alice"); err != nil {
+			t.Fatalf("Append() error = %v", err)
+		}
+	}
+
+	var tooLargeErr *AppendTooLargeError
+	if _, err := m.Append(ctx, key, make([]byte, MaxUploadSize), "SHA256:alice"); !errors.As(err, &tooLargeErr) {
+		t.Errorf("Append() beyond the limit error = %v, want %T", err, tooLargeErr)
+	}
+
+	snippet, err := m.View(ctx, key, "")
+	if err != nil {
+		t.Fatalf("View() error = %v", err)
+	}
+	if string(snippet.Code) != "$ uname\n$ uptime\nup 3 days\n" {
+		t.Errorf("Code = %q", snippet.Code)
+	}
+	chunks := snippet.SplitChunks()
+	if len(chunks) != 3 || string(chunks[0]) != "$ uname\n" || string(chunks[2]) != "up 3 days\n" {
+		t.Errorf("SplitChunks() = %q", chunks)
+	}
+	if !snippet.Chunks[0].At.Equal(snippet.CreatedAt) {
+		t.Errorf("first chunk at %v, want it created at %v", snippet.Chunks[0].At, snippet.CreatedAt)
+	}
+}
+
+func TestSnippetManager_ViewLimit(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	m := NewSnippetManager(store, false, DefaultMaxRevisions)
+
+	key, _, err := m.Create(ctx, []byte("token"), SnippetOptions{TTL: time.Hour, SnippetMeta: SnippetMeta{Views: 2}})
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	for _, want := range []int{1, 0} {
+		snippet, err := m.View(ctx, key, "")
+		if err != nil {
+			t.Fatalf("View() error = %v", err)
+		}
+		if snippet.ViewsLeft != want {
+			t.Errorf("ViewsLeft = %d, want %d", snippet.ViewsLeft, want)
+		}
+	}
+
+	// the counter is gone while the snippet isn't, it isn't brought back
+	key, _, err = m.Create(ctx, []byte("token"), SnippetOptions{TTL: time.Hour, SnippetMeta: SnippetMeta{Views: 2}})
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if err := store.Del(ctx, viewsKey(key)); err != nil {
+		t.Fatalf("Del() error = %v", err)
+	}
+	if _, err := m.View(ctx, key, ""); !errors.Is(err, ErrViewLimitReached) {
+		t.Errorf("View() error = %v, want %v", err, ErrViewLimitReached)
+	}
+	if _, err := store.Get(ctx, viewsKey(key)); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("views counter is left without a TTL, Get() error = %v", err)
+	}
+}
+
+// slowStore takes a while to answer reads, like a store over the network,
+// so concurrent changes overlap.
+type slowStore struct {
+	Store
+}
+
+func (s slowStore) Get(ctx context.Context, key string) ([]byte, error) {
+	raw, err := s.Store.Get(ctx, key)
+	time.Sleep(time.Millisecond)
+	return raw, err
+}
+
+func TestSnippetManager_AppendConcurrently(t *testing.T) {
+	ctx := context.Background()
+	m := NewSnippetManager(slowStore{NewMemoryStore()}, false, DefaultMaxRevisions)
+
+	key, _, err := m.Create(ctx, []byte("start\n"), SnippetOptions{TTL: time.Hour, SnippetMeta: SnippetMeta{Owner: "SHA256:alice"}})
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	const appends = 50
+	var wg sync.WaitGroup
+	for i := range appends {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := m.Append(ctx, key, []byte(fmt.Sprintf("line %d\n", i)), "SHA256:alice"); err != nil {
+				t.Errorf("Append() error = %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	snippet, err := m.View(ctx, key, "")
+	if err != nil {
+		t.Fatalf("View() error = %v", err)
+	}
+	if chunks := snippet.SplitChunks(); len(chunks) != appends+1 {
+		t.Errorf("len(SplitChunks()) = %d, want %d", len(chunks), appends+1)
+	}
+	for i := range appends {
+		if !strings.Contains(string(snippet.Code), fmt.Sprintf("line %d\n", i)) {
+			t.Errorf("Code is missing line %d", i)
+		}
+	}
+	if len(m.keyLocks) != 0 {
+		t.Errorf("%d key locks are left over", len(m.keyLocks))
+	}
+}
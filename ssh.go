@@ -1,15 +1,16 @@
 package main
 
 import (
-	"crypto/sha1"
-	"encoding/hex"
+	"bytes"
+	"errors"
 	"fmt"
 	"github.com/gliderlabs/ssh"
-	"github.com/google/uuid"
+	gossh "golang.org/x/crypto/ssh"
 	"io"
 	"net"
-	"strconv"
+	"path"
 	"strings"
+	"text/tabwriter"
 	"time"
 )
 
@@ -17,6 +18,7 @@ var (
 	Reset  = "\033[0m"
 	Red    = "\033[31m"
 	Green  = "\033[32m"
+	Yellow = "\033[33m"
 	Gray   = "\033[37m"
 	Purple = "\033[35m"
 )
@@ -32,7 +34,7 @@ const (
 
 type SSHServer struct {
 	logger        Logger
-	store         Store
+	snippets      *SnippetManager
 	tunnelManager *TunnelManager
 	rateLimiter   *RateLimiter
 	host          string
@@ -40,47 +42,55 @@ type SSHServer struct {
 
 func NewSSHServer(
 	logger Logger,
-	store Store,
+	snippets *SnippetManager,
 	rateLimiter *RateLimiter,
 	tunnelManager *TunnelManager,
 	host string,
 ) *SSHServer {
 	return &SSHServer{
 		logger:        logger,
-		store:         store,
+		snippets:      snippets,
 		rateLimiter:   rateLimiter,
 		tunnelManager: tunnelManager,
 		host:          host,
 	}
 }
 
-func (s *SSHServer) parseCommand(cmd string) (Command, string) {
-	parts := strings.Split(cmd, "=")
-	if len(parts) != 2 {
-		return CmdUnknown, ""
-	}
-	return ParseCmd(parts[0]), parts[1]
-}
-
 func (s *SSHServer) handleTunnelCommand(sess ssh.Session) {
-	key := s.genKey()
+	key := GenKey()
 	s.logger.Debugw("creating tunnel", "key", key)
 
-	tunnel := NewTunnelData(sess)
+	tunnel := NewTunnelData()
+	tunnel.Owner = sessionOwner(sess)
 	s.tunnelManager.AddTunnel(key, tunnel)
 
 	_, err := sess.Write([]byte(s.genTunnelCreatedResponse(key)))
 	if err != nil {
 		s.logger.Errorw("failed to write to ssh session", "error", err)
+		tunnel.Done()
 		return
 	}
 
-	tunnel.Wait()
-	s.tunnelManager.RemoveTunnel(key)
+	// the tunnel stays registered after the sender is done, so viewers can
+	// still replay it until the tunnel manager cleans it up
+	go func() {
+		_, err := io.Copy(tunnel, sess)
+		tunnel.Close(err)
+	}()
 
-	s.logger.Debugw("transfer over tunnel complete", "key", key)
+	tunnel.Wait()
 
-	_, err = sess.Write([]byte(s.genDataTransferredOverTunnelResponse()))
+	switch err := tunnel.Err(); {
+	case errors.Is(err, ErrStreamSizeExceeded):
+		s.logger.Warnw("stream size exceeded", "key", key)
+		_, err = sess.Write([]byte(s.genStreamSizeExceededResponse()))
+	case err != nil:
+		s.logger.Errorw("failed to read from ssh session", "key", key, "error", err)
+		return
+	default:
+		s.logger.Debugw("transfer over tunnel complete", "key", key, "bytes", tunnel.Size())
+		_, err = sess.Write([]byte(s.genDataTransferredOverTunnelResponse()))
+	}
 	if err != nil {
 		s.logger.Errorw("failed to write to ssh session", "error", err)
 		return
@@ -88,83 +98,502 @@ func (s *SSHServer) handleTunnelCommand(sess ssh.Session) {
 }
 
 func (s *SSHServer) handleSessionWithCommand(sess ssh.Session) {
-	cmd := sess.Command()[0]
-	s.logger.Debugw("received command", "command", cmd)
+	s.logger.Debugw("received command", "command", RedactArgs(sess.Command()))
 
-	cmdType, cmdValue := s.parseCommand(cmd)
-	switch cmdType {
-	case CmdTunnel:
-		shouldTunnel, err := strconv.ParseBool(cmdValue)
+	opts, err := ParseOptions(sess.Command())
+	if err != nil {
+		s.logger.Infow("invalid command", "command", RedactArgs(sess.Command()), "error", err)
+		_, err = sess.Stderr().Write([]byte(s.genInvalidOptionsResponse(err) + s.genHelpResponse()))
 		if err != nil {
-			s.logger.Errorw("failed to parse tunnel command", "error", err)
-			return
+			s.logger.Errorw("failed to write to ssh session", "error", err)
 		}
-		if !shouldTunnel {
-			s.handleBasicSession(sess, MaxTTL)
-			return
+		return
+	}
+
+	switch {
+	case opts.Help:
+		_, err = sess.Write([]byte(s.genHelpResponse()))
+		if err != nil {
+			s.logger.Errorw("failed to write to ssh session", "error", err)
 		}
+	case opts.Get != "":
+		s.handleGetCommand(sess, opts.Get, opts.Password)
+	case opts.Tunnel:
 		s.handleTunnelCommand(sess)
-	case CmdTTL:
-		userTTL, err := strconv.Atoi(cmdValue)
+	case opts.List:
+		s.handleListCommand(sess)
+	case opts.Delete != "":
+		s.handleDeleteCommand(sess, opts.Delete)
+	case opts.Update != "":
+		s.handleUpdateCommand(sess, opts)
+	case opts.Append != "":
+		s.handleAppendCommand(sess, opts.Append)
+	default:
+		s.handleBasicSession(sess, opts)
+	}
+}
+
+// handleGetCommand writes a stored snippet back to the session. Terminals
+// (ssh -t) get it syntax highlighted, everything else gets the raw bytes.
+func (s *SSHServer) handleGetCommand(sess ssh.Session, key, password string) {
+	// encrypted snippets can't be read here, so they must not use up a view
+	meta, err := s.snippets.Meta(sess.Context(), key)
+	if err == nil && meta.Encrypted {
+		_, err = sess.Stderr().Write([]byte(s.genSnippetEncryptedResponse(key)))
 		if err != nil {
-			s.logger.Errorw("failed to parse ttl command", "error", err)
-			return
+			s.logger.Errorw("failed to write to ssh session", "error", err)
+		}
+		return
+	}
+
+	snippet, err := s.snippets.View(sess.Context(), key, password)
+	switch {
+	case errors.Is(err, ErrKeyNotFound):
+		s.logger.Infow("key not found", "key", key)
+		_, err = sess.Stderr().Write([]byte(s.genSnippetNotFoundResponse(key)))
+		if err != nil {
+			s.logger.Errorw("failed to write to ssh session", "error", err)
+		}
+		return
+	case errors.Is(err, ErrPasswordRequired), errors.Is(err, ErrWrongPassword):
+		if errors.Is(err, ErrWrongPassword) {
+			s.countWrongPassword(sess, key)
+		}
+		_, err = sess.Stderr().Write([]byte(s.genPasswordResponse(key, err)))
+		if err != nil {
+			s.logger.Errorw("failed to write to ssh session", "error", err)
+		}
+		return
+	case errors.Is(err, ErrSnippetBurned):
+		s.logger.Infow("snippet already burned", "key", key)
+		_, err = sess.Stderr().Write([]byte(s.genSnippetBurnedResponse(key)))
+		if err != nil {
+			s.logger.Errorw("failed to write to ssh session", "error", err)
+		}
+		return
+	case errors.Is(err, ErrViewLimitReached):
+		s.logger.Infow("snippet view limit reached", "key", key)
+		_, err = sess.Stderr().Write([]byte(s.genViewLimitReachedResponse(key)))
+		if err != nil {
+			s.logger.Errorw("failed to write to ssh session", "error", err)
+		}
+		return
+	case err != nil:
+		s.logger.Errorw("failed to get key from store", "error", err)
+		return
+	}
+	code := snippet.JoinedCode()
+	s.logger.Debugw("fetched code from store", "key", key, "bytes", len(code))
+
+	if _, _, isPty := sess.Pty(); isPty {
+		highlighted, err := HighlightANSI(code, snippet.Lang, snippet.Name)
+		if err != nil {
+			s.logger.Errorw("failed to highlight code", "key", key, "error", err)
+		} else {
+			code = highlighted
+		}
+		// the client terminal is in raw mode, so line feeds need a carriage return
+		code = bytes.ReplaceAll(code, []byte("\n"), []byte("\r\n"))
+	}
+
+	_, err = sess.Write(code)
+	if err != nil {
+		s.logger.Errorw("failed to write to ssh session", "error", err)
+		return
+	}
+}
+
+// handleListCommand writes a table of the snippets owned by the key of the
+// session that haven't expired yet.
+func (s *SSHServer) handleListCommand(sess ssh.Session) {
+	owner := sessionOwner(sess)
+	if owner == "" {
+		_, err := sess.Stderr().Write([]byte(s.genKeyRequiredResponse()))
+		if err != nil {
+			s.logger.Errorw("failed to write to ssh session", "error", err)
+		}
+		return
+	}
+
+	snippets, err := s.snippets.List(sess.Context(), owner)
+	if err != nil {
+		s.logger.Errorw("failed to list snippets", "owner", owner, "error", err)
+		return
+	}
+	s.logger.Debugw("listed snippets", "owner", owner, "count", len(snippets))
+
+	_, err = sess.Write([]byte(s.genListResponse(snippets)))
+	if err != nil {
+		s.logger.Errorw("failed to write to ssh session", "error", err)
+	}
+}
+
+func (s *SSHServer) handleDeleteCommand(sess ssh.Session, key string) {
+	var output string
+	err := s.snippets.Delete(sess.Context(), key, sessionOwner(sess))
+	switch {
+	case errors.Is(err, ErrKeyNotFound):
+		s.logger.Infow("key not found", "key", key)
+		output = s.genSnippetNotFoundResponse(key)
+	case errors.Is(err, ErrNotOwner):
+		s.logger.Infow("delete by someone else than the owner", "key", key)
+		output = s.genNotOwnerResponse(key, CmdDelete)
+	case err != nil:
+		s.logger.Errorw("failed to delete snippet", "key", key, "error", err)
+		return
+	default:
+		s.logger.Debugw("deleted snippet", "key", key)
+		_, err = sess.Write([]byte(s.genDeletedResponse(key)))
+		if err != nil {
+			s.logger.Errorw("failed to write to ssh session", "error", err)
 		}
-		s.handleTTLCommand(sess, userTTL)
+		return
+	}
+	_, err = sess.Stderr().Write([]byte(output))
+	if err != nil {
+		s.logger.Errorw("failed to write to ssh session", "error", err)
+	}
+}
+
+// handleUpdateCommand replaces the code of a snippet owned by the key of the
+// session with the upload, so the link that was shared shows the fix.
+func (s *SSHServer) handleUpdateCommand(sess ssh.Session, opts Options) {
+	key := opts.Update
+	owner, upload, ok := s.readOwnedUpload(sess, key, CmdUpdate)
+	if !ok {
+		return
+	}
+
+	ttl := time.Duration(0)
+	if opts.TTL != 0 {
+		ttl = ClampTTL(opts.TTL)
+	}
+	record, err := s.snippets.Update(sess.Context(), key, upload.Code, SnippetOptions{
+		TTL: ttl,
+		SnippetMeta: SnippetMeta{
+			Lang:      opts.Lang,
+			Name:      opts.Name,
+			Truncated: upload.Truncated,
+			Received:  upload.Received,
+			Owner:     owner,
+		},
+	})
+	if err != nil {
+		s.writeModifyError(sess, key, CmdUpdate, err)
+		return
+	}
+	s.logger.Debugw("updated snippet", "key", key, "revision", record.Revision, "bytes", len(upload.Code))
+
+	_, err = sess.Write([]byte(s.genUpdatedResponse(key, record)))
+	if err != nil {
+		s.logger.Errorw("failed to write to ssh session", "error", err)
+	}
+}
+
+// handleAppendCommand adds the upload to the end of a snippet owned by the
+// key of the session, to gather the output of several commands in one link.
+func (s *SSHServer) handleAppendCommand(sess ssh.Session, key string) {
+	owner, upload, ok := s.readOwnedUpload(sess, key, CmdAppend)
+	if !ok {
+		return
+	}
+	if upload.Truncated {
+		// the upload alone is larger than a snippet can be
+		s.writeModifyError(sess, key, CmdAppend, &AppendTooLargeError{Appended: upload.Received})
+		return
+	}
+
+	record, err := s.snippets.Append(sess.Context(), key, upload.Code, owner)
+	if err != nil {
+		s.writeModifyError(sess, key, CmdAppend, err)
+		return
+	}
+	s.logger.Debugw("appended to snippet", "key", key, "chunks", len(record.Chunks), "bytes", len(upload.Code))
+
+	_, err = sess.Write([]byte(s.genAppendedResponse(key, record, len(upload.Code))))
+	if err != nil {
+		s.logger.Errorw("failed to write to ssh session", "error", err)
+	}
+}
+
+// readOwnedUpload reads the upload that changes the snippet under key. The
+// snippet is checked before, so nobody uploads in vain to a snippet they
+// can't change.
+func (s *SSHServer) readOwnedUpload(sess ssh.Session, key string, cmd Command) (string, *Upload, bool) {
+	owner := sessionOwner(sess)
+	if owner == "" {
+		_, err := sess.Stderr().Write([]byte(s.genKeyRequiredResponse()))
+		if err != nil {
+			s.logger.Errorw("failed to write to ssh session", "error", err)
+		}
+		return "", nil, false
+	}
+
+	meta, err := s.snippets.Meta(sess.Context(), key)
+	if err == nil {
+		err = meta.CheckOwner(owner)
+	}
+	if err == nil && meta.Encrypted {
+		err = ErrEncryptedUpdate
+	}
+	if err != nil {
+		s.writeModifyError(sess, key, cmd, err)
+		return "", nil, false
+	}
+
+	upload, err := s.snippets.ReadUpload(sess)
+	var tooLargeErr *UploadTooLargeError
+	switch {
+	case errors.As(err, &tooLargeErr):
+		s.logger.Infow("upload too large", "received", tooLargeErr.Received)
+		_, err = sess.Stderr().Write([]byte(s.genUploadTooLargeResponse(tooLargeErr)))
+		if err != nil {
+			s.logger.Errorw("failed to write to ssh session", "error", err)
+		}
+		return "", nil, false
+	case err != nil:
+		s.logger.Errorw("failed to read from ssh session", "error", err)
+		return "", nil, false
+	}
+	return owner, upload, true
+}
+
+func (s *SSHServer) writeModifyError(sess ssh.Session, key string, cmd Command, err error) {
+	var output string
+	var appendErr *AppendTooLargeError
+	switch {
+	case errors.Is(err, ErrKeyNotFound):
+		s.logger.Infow("key not found", "key", key)
+		output = s.genSnippetNotFoundResponse(key)
+	case errors.Is(err, ErrNotOwner):
+		s.logger.Infow("change by someone else than the owner", "key", key, "command", cmd.String())
+		output = s.genNotOwnerResponse(key, cmd)
+	case errors.Is(err, ErrEncryptedUpdate):
+		output = fmt.Sprintf("%sSnippet %s is encrypted and can't be changed, upload a new one instead.%s\n", Red, key, Reset)
+	case errors.Is(err, ErrFilesAppend):
+		output = fmt.Sprintf("%sSnippet %s holds several files, it can only be updated as a whole.%s\n", Red, key, Reset)
+	case errors.As(err, &appendErr):
+		s.logger.Infow("append too large", "key", key, "size", appendErr.Size, "appended", appendErr.Appended)
+		output = fmt.Sprintf("%sNothing was appended: %s.%s\n", Red, appendErr, Reset)
 	default:
-		s.logger.Warnw("unknown command", "command", cmd)
+		s.logger.Errorw("failed to change snippet", "key", key, "command", cmd.String(), "error", err)
+		return
+	}
+	_, err = sess.Stderr().Write([]byte(output))
+	if err != nil {
+		s.logger.Errorw("failed to write to ssh session", "error", err)
 	}
 }
 
-func (s *SSHServer) handleTTLCommand(sess ssh.Session, userTTL int) {
-	ttl := time.Duration(userTTL) * time.Second
-	if ttl < MinTTL {
-		s.logger.Debugw("user ttl too low; falling back on MinTTL", "ttl", ttl, "min_ttl", MinTTL)
-		ttl = MinTTL
+func (s *SSHServer) handleBasicSession(sess ssh.Session, opts Options) {
+	ttl := MaxTTL
+	if opts.TTL != 0 {
+		ttl = ClampTTL(opts.TTL)
+		if ttl != opts.TTL {
+			s.logger.Debugw("user ttl out of bounds; clamping", "ttl", opts.TTL, "min_ttl", MinTTL, "max_ttl", MaxTTL)
+		}
+	}
+
+	upload, files, ok := s.readSnippetUpload(sess, opts)
+	if !ok {
+		return
+	}
+	code := upload.Code
+	meta := SnippetMeta{
+		Lang:      opts.Lang,
+		Name:      opts.Name,
+		Truncated: upload.Truncated,
+		Received:  upload.Received,
+		Burn:      opts.Burn,
+		Views:     opts.Views,
+		Owner:     sessionOwner(sess),
+	}
+
+	if !opts.Encrypt {
+		s.logger.Debugw("writing to store", "code", string(code))
+	}
+	key, secret, err := s.snippets.Create(sess.Context(), code, SnippetOptions{
+		TTL:         ttl,
+		Password:    opts.Password,
+		Encrypt:     opts.Encrypt,
+		Files:       files,
+		SnippetMeta: meta,
+	})
+	if err != nil {
+		s.logger.Errorw("failed to create snippet", "error", err, "bytes", len(code))
+		return
 	}
-	if ttl > MaxTTL {
-		s.logger.Debugw("user ttl too high; falling back on MaxTTL", "ttl", ttl, "max_ttl", MaxTTL)
-		ttl = MaxTTL
+	s.logger.Debugw("wrote bytes", "key", key, "bytes", len(code), "files", len(files))
+
+	_, err = sess.Write([]byte(s.genBasicResponse(key, secret, meta, files, opts.Password != "")))
+	if err != nil {
+		s.logger.Errorw("failed to write to ssh session", "error", err)
+		return
 	}
-	s.handleBasicSession(sess, ttl)
+
 }
 
-func (s *SSHServer) handleBasicSession(sess ssh.Session, ttl time.Duration) {
-	buf := make([]byte, MaxUploadSize)
-	// read from ssh session 1MB at a time
-	n, err := io.ReadFull(sess, buf)
-	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+// readSnippetUpload reads the upload of a new snippet. Tar archives are
+// unpacked into a multi-file snippet, whether or not files was given.
+func (s *SSHServer) readSnippetUpload(sess ssh.Session, opts Options) (*Upload, []SnippetFile, bool) {
+	upload, files, err := s.snippets.ReadSnippetUpload(sess, opts)
+	var tooLargeErr *UploadTooLargeError
+	switch {
+	case errors.As(err, &tooLargeErr):
+		s.logger.Infow("upload too large", "received", tooLargeErr.Received)
+		_, err = sess.Stderr().Write([]byte(s.genUploadTooLargeResponse(tooLargeErr)))
+		if err != nil {
+			s.logger.Errorw("failed to write to ssh session", "error", err)
+		}
+		return nil, nil, false
+	case err != nil && opts.Files:
+		s.logger.Infow("invalid archive", "error", err)
+		_, err = sess.Stderr().Write([]byte(fmt.Sprintf("%sUpload rejected: %s.%s\n", Red, err, Reset)))
+		if err != nil {
+			s.logger.Errorw("failed to write to ssh session", "error", err)
+		}
+		return nil, nil, false
+	case err != nil:
 		s.logger.Errorw("failed to read from ssh session", "error", err)
+		return nil, nil, false
+	}
+	return upload, files, true
+}
+
+// handleSCPSession creates a snippet from the files copied with scp. A
+// single file keeps its name, several files make a multi-file snippet.
+// The output of scp is its protocol, the link goes to stderr.
+func (s *SSHServer) handleSCPSession(sess ssh.Session) {
+	s.logger.Debugw("received scp command", "command", sess.Command())
+	if !isSCPSink(sess.Command()) {
+		s.exitSCP(sess, errors.New("snippets can't be copied from the server, fetch them with get=KEY"))
 		return
 	}
 
-	key := s.genKey()
-	s.logger.Debugw("writing to store", "key", key, "code", string(buf[:n]))
-	err = s.store.Set(sess.Context(), key, string(buf[:n]), ttl)
-	s.logger.Debugw("wrote bytes", "bytes", n)
+	code, files, err := s.snippets.ReadSCP(sess)
 	if err != nil {
-		s.logger.Errorw("failed to write to redis",
-			"error",
-			err,
-			"key",
-			key,
-			"code",
-			string(buf[:n]),
-		)
+		s.logger.Infow("scp upload failed", "error", err)
+		s.exitSCP(sess, err)
 		return
 	}
-	_, err = s.store.Incr(sess.Context(), CodeUploadedCountKey)
+	s.createCopiedSnippet(sess, code, files, s.exitSCP)
+}
+
+// handleSFTPSubsystem creates a snippet from the files written over SFTP,
+// which scp uses from OpenSSH 9.0 on unless it is run with -O. Subsystems
+// don't go through HandleSession, so the rate limit is checked here.
+func (s *SSHServer) handleSFTPSubsystem(sess ssh.Session) {
+	rateLimited, err := s.isRateLimited(sess)
 	if err != nil {
-		s.logger.Errorw("failed to increment code uploaded count", "error", err)
+		s.logger.Errorw("failed to check rate limit", "error", err)
 		return
 	}
+	if rateLimited {
+		s.logger.Infow("rate limited", "ip", sess.RemoteAddr().String())
+		s.exitSFTP(sess, ErrCopyRateLimited)
+		return
+	}
+
+	code, files, err := s.snippets.ReadSFTP(sess)
+	if err != nil {
+		s.logger.Infow("sftp upload failed", "error", err)
+		s.exitSFTP(sess, err)
+		return
+	}
+	s.createCopiedSnippet(sess, code, files, s.exitSFTP)
+}
+
+// createCopiedSnippet stores the files copied with scp. A single file
+// keeps its name, several files make a multi-file snippet. The output of
+// scp is its protocol, the link goes to stderr.
+func (s *SSHServer) createCopiedSnippet(sess ssh.Session, code []byte, files []SnippetFile, exit func(ssh.Session, error)) {
+	meta := SnippetMeta{
+		Received: int64(len(code)),
+		Owner:    sessionOwner(sess),
+	}
+	if len(files) == 1 {
+		// the path was checked by ParseFilePath, it may be below a directory copied with -r
+		meta.Name = path.Base(files[0].Path)
+		files = nil
+	}
+
+	key, _, err := s.snippets.Create(sess.Context(), code, SnippetOptions{
+		TTL:         MaxTTL,
+		Files:       files,
+		SnippetMeta: meta,
+	})
+	if err != nil {
+		s.logger.Errorw("failed to create snippet", "error", err, "bytes", len(code))
+		exit(sess, errors.New("the snippet could not be stored"))
+		return
+	}
+	s.logger.Debugw("wrote bytes", "key", key, "bytes", len(code), "files", len(files))
+
+	_, err = sess.Stderr().Write([]byte(s.genBasicResponse(key, "", meta, files, false)))
+	if err != nil {
+		s.logger.Errorw("failed to write to ssh session", "error", err)
+	}
+	err = sess.Exit(0)
+	if err != nil {
+		s.logger.Errorw("failed to exit ssh session", "error", err)
+	}
+}
 
-	_, err = sess.Write([]byte(s.genBasicResponse(key)))
+// exitSCP reports err to scp, which prints it, and fails the session.
+func (s *SSHServer) exitSCP(sess ssh.Session, err error) {
+	err = WriteSCPError(sess, copyError(err))
 	if err != nil {
 		s.logger.Errorw("failed to write to ssh session", "error", err)
+	}
+	err = sess.Exit(1)
+	if err != nil {
+		s.logger.Errorw("failed to exit ssh session", "error", err)
+	}
+}
+
+// exitSFTP reports err on stderr, the SFTP client only shows a generic
+// failure, and fails the session.
+func (s *SSHServer) exitSFTP(sess ssh.Session, err error) {
+	output := fmt.Sprintf("%scodesnap.sh: %s%s\n", Red, copyError(err), Reset)
+	_, err = sess.Stderr().Write([]byte(output))
+	if err != nil {
+		s.logger.Errorw("failed to write to ssh session", "error", err)
+	}
+	err = sess.Exit(1)
+	if err != nil {
+		s.logger.Errorw("failed to exit ssh session", "error", err)
+	}
+}
+
+func copyError(err error) error {
+	var tooLargeErr *UploadTooLargeError
+	if errors.As(err, &tooLargeErr) {
+		return fmt.Errorf("upload rejected: %w, nothing was stored", err)
+	}
+	return err
+}
+
+// countWrongPassword counts a wrong password against the rate limit on top
+// of the session itself, so passwords can't be brute forced.
+func (s *SSHServer) countWrongPassword(sess ssh.Session, key string) {
+	ip, _, err := net.SplitHostPort(sess.RemoteAddr().String())
+	if err != nil {
+		s.logger.Errorw("failed to parse remote address", "error", err)
 		return
 	}
+	s.logger.Infow("wrong password", "key", key, "ip", ip)
+	err = s.rateLimiter.Count(sess.Context(), ip)
+	if err != nil {
+		s.logger.Errorw("failed to count wrong password", "error", err)
+	}
+}
 
+// sessionOwner returns the SHA256 fingerprint of the key the session was
+// authenticated with, or an empty string for keyless sessions. It is taken
+// from the permissions set by authOptions rather than sess.PublicKey(),
+// which also holds keys a client only offered without proving it has them.
+func sessionOwner(sess ssh.Session) string {
+	return sess.Permissions().Extensions[ownerExtension]
 }
 
 func (s *SSHServer) isRateLimited(sess ssh.Session) (bool, error) {
@@ -184,7 +613,8 @@ func (s *SSHServer) isRateLimited(sess ssh.Session) (bool, error) {
 func (s *SSHServer) HandleSession(sess ssh.Session) {
 	defer func() {
 		err := sess.Close()
-		if err != nil {
+		// scp sessions exit with a status, which closes them already
+		if err != nil && !errors.Is(err, io.EOF) {
 			s.logger.Errorw("failed to close ssh session", "error", err)
 		}
 	}()
@@ -196,6 +626,10 @@ func (s *SSHServer) HandleSession(sess ssh.Session) {
 	}
 	if rateLimited {
 		s.logger.Infow("rate limited", "ip", sess.RemoteAddr().String())
+		if IsSCPCommand(sess.Command()) {
+			s.exitSCP(sess, ErrCopyRateLimited)
+			return
+		}
 		_, err = sess.Write([]byte(s.genRateLimitedResponse()))
 		if err != nil {
 			s.logger.Errorw("failed to write to ssh session", "error", err)
@@ -204,16 +638,26 @@ func (s *SSHServer) HandleSession(sess ssh.Session) {
 		return
 	}
 
+	if IsSCPCommand(sess.Command()) {
+		s.handleSCPSession(sess)
+		return
+	}
 	if len(sess.Command()) > 0 {
 		s.handleSessionWithCommand(sess)
 		return
 	}
 
-	s.handleBasicSession(sess, MaxTTL)
+	s.handleBasicSession(sess, Options{})
 }
 
 func (s *SSHServer) genDataTransferredOverTunnelResponse() string {
-	output := fmt.Sprintf("%sCode transferred successfully! %s\n\n", Green, Reset)
+	output := fmt.Sprintf("%sCode transferred successfully! %s\n", Green, Reset)
+	output += fmt.Sprintf("%sThe link stays available for replay for up to %d minutes.%s\n\n", Gray, int(TunnelTTL.Minutes()), Reset)
+	return output
+}
+
+func (s *SSHServer) genStreamSizeExceededResponse() string {
+	output := fmt.Sprintf("%sStream size exceeded the %d MB limit, the tunnel was closed.%s\n\n", Red, MaxStreamSize/1024/1024, Reset)
 	return output
 }
 
@@ -231,22 +675,208 @@ func (s *SSHServer) genTunnelCreatedResponse(key string) string {
 	return output
 }
 
-func (s *SSHServer) genBasicResponse(key string) string {
+func (s *SSHServer) genBasicResponse(key, secret string, meta SnippetMeta, files []SnippetFile, protected bool) string {
 	output := fmt.Sprintf("%s+------------------------+\n", Gray)
 	output += fmt.Sprintf("|    💻 codesnap.sh 💻   |\n")
 	output += fmt.Sprintf("+------------------------+%s\n\n", Reset)
 
 	output += fmt.Sprintf("%sYour code has been successfully uploaded! 🚀%s\n\n", Green, Reset)
 
-	linkToCode := fmt.Sprintf("%s/c/%s", s.host, key)
+	if meta.Truncated {
+		output += fmt.Sprintf("%s⚠ Your input was truncated: received %s, only the first %s were stored.%s\n\n",
+			Yellow, FormatReceived(meta.Received), FormatSize(MaxUploadSize), Reset)
+	}
+
+	linkToCode := SnippetLink(s.host, key, secret)
 	link := fmt.Sprintf("%s%s%s", Purple, linkToCode, Reset)
 	output += fmt.Sprintf("Link: %s\n\n", link)
 
+	if len(files) > 0 {
+		output += fmt.Sprintf("%s📁 %d files:%s\n", Green, len(files), Reset)
+		for _, file := range files {
+			output += fmt.Sprintf("  %s%s/%s%s\n", Purple, linkToCode, file.Path, Reset)
+		}
+		output += "\n"
+	}
+	if meta.Owner != "" {
+		output += fmt.Sprintf("%sOwned by your key %s%s\n\n", Gray, meta.Owner, Reset)
+	}
+	if secret != "" {
+		output += fmt.Sprintf("%s🔑 Encrypted: the key after # is not stored anywhere, don't lose the link.%s\n\n", Yellow, Reset)
+	}
+	if meta.Burn {
+		output += fmt.Sprintf("%s🔥 Burn after reading: the snippet is deleted as soon as it is viewed.%s\n", Yellow, Reset)
+		output += fmt.Sprintf("Check if it was read: %scurl %s/s/%s%s\n\n", Purple, s.host, key, Reset)
+	}
+	if protected {
+		output += fmt.Sprintf("%s🔒 Password protected: viewers are asked for the password.%s\n", Yellow, Reset)
+		output += fmt.Sprintf("Get it raw: %scurl -H '%s: <password>' %s/r/%s%s\n\n", Purple, PasswordHeader, s.host, key, Reset)
+	}
+	if meta.Views > 0 {
+		output += fmt.Sprintf("%s👀 The snippet is deleted after %d views.%s\n", Yellow, meta.Views, Reset)
+		output += fmt.Sprintf("Check how many views are left: %scurl %s/s/%s%s\n\n", Purple, s.host, key, Reset)
+	}
+
 	output += fmt.Sprintf("%s+------------------------+\n", Green)
 
 	return output
 }
 
+func (s *SSHServer) genListResponse(snippets []*Snippet) string {
+	if len(snippets) == 0 {
+		return fmt.Sprintf("%sYou have no snippets, they are listed here once you upload them with this key.%s\n", Gray, Reset)
+	}
+
+	var buf strings.Builder
+	tw := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "KEY\tLINK\tSIZE\tEXPIRES IN\tVIEWS")
+	for _, snippet := range snippets {
+		expiresIn := "-"
+		if !snippet.ExpiresAt.IsZero() {
+			expiresIn = FormatDuration(time.Until(snippet.ExpiresAt))
+		}
+		views := "-"
+		switch {
+		case snippet.Burn:
+			views = "burn"
+		case snippet.Views > 0:
+			views = fmt.Sprintf("%d/%d left", snippet.ViewsLeft, snippet.Views)
+		}
+		// the secret of encrypted snippets isn't stored, so their link is incomplete
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", snippet.Key, SnippetLink(s.host, snippet.Key, ""),
+			FormatSize(int64(len(snippet.Code))), expiresIn, views)
+	}
+	tw.Flush()
+	return buf.String()
+}
+
+func (s *SSHServer) genKeyRequiredResponse() string {
+	output := fmt.Sprintf("%sYou connected without an ssh key, so there are no snippets owned by you.%s\n", Red, Reset)
+	output += fmt.Sprintf("Snippets uploaded with a key can be listed, updated and deleted with that key.\n")
+	return output
+}
+
+func (s *SSHServer) genNotOwnerResponse(key string, cmd Command) string {
+	output := fmt.Sprintf("%sSnippet %s wasn't uploaded with your ssh key, only its owner can %s it.%s\n", Red, key, cmd, Reset)
+	return output
+}
+
+func (s *SSHServer) genUpdatedResponse(key string, record *SnippetRecord) string {
+	output := fmt.Sprintf("%sSnippet %s has been updated to revision %d! 🚀%s\n\n", Green, key, record.Revision, Reset)
+
+	if record.Truncated {
+		output += fmt.Sprintf("%s⚠ Your input was truncated: received %s, only the first %s were stored.%s\n\n",
+			Yellow, FormatReceived(record.Received), FormatSize(MaxUploadSize), Reset)
+	}
+
+	link := fmt.Sprintf("%s%s%s", Purple, SnippetLink(s.host, key, ""), Reset)
+	output += fmt.Sprintf("Link: %s\n", link)
+	if !record.ExpiresAt.IsZero() {
+		output += fmt.Sprintf("%sExpires in %s, anyone viewing it sees the new version.%s\n", Gray, FormatDuration(time.Until(record.ExpiresAt)), Reset)
+	}
+	return output
+}
+
+func (s *SSHServer) genDeletedResponse(key string) string {
+	output := fmt.Sprintf("%sSnippet %s has been deleted.%s\n", Green, key, Reset)
+	return output
+}
+
+func (s *SSHServer) genAppendedResponse(key string, record *SnippetRecord, appended int) string {
+	output := fmt.Sprintf("%sAppended %s to snippet %s, it holds %s of %s now.%s\n",
+		Green, FormatSize(int64(appended)), key, FormatSize(int64(len(record.Code))), FormatSize(MaxUploadSize), Reset)
+	output += fmt.Sprintf("Link: %s%s%s\n", Purple, SnippetLink(s.host, key, ""), Reset)
+	return output
+}
+
+func (s *SSHServer) genHelpResponse() string {
+	output := fmt.Sprintf("%s+------------------------+\n", Gray)
+	output += fmt.Sprintf("|    💻 codesnap.sh 💻   |\n")
+	output += fmt.Sprintf("+------------------------+%s\n\n", Reset)
+
+	output += fmt.Sprintf("%sUsage:%s ssh %s [option=value ...] < file\n\n", Green, Reset, s.hostname())
+
+	output += fmt.Sprintf("%sOptions:%s\n", Green, Reset)
+	for _, cmd := range Commands {
+		output += fmt.Sprintf("  %s%-18s%s %s\n", Purple, cmd.Usage(), Reset, cmd.Description())
+	}
+	output += "\n"
+
+	output += fmt.Sprintf("%sLimits:%s\n", Green, Reset)
+	output += fmt.Sprintf("  max upload size    %d MB\n", MaxUploadSize/1024/1024)
+	output += fmt.Sprintf("  rate limit         %d sessions per %s\n\n", MaxAttempts, RateLimiterTTL)
+
+	output += fmt.Sprintf("%sExamples:%s\n", Green, Reset)
+	output += fmt.Sprintf("  ssh %s ttl=600 lang=go name=main.go < main.go\n", s.hostname())
+	output += fmt.Sprintf("  tar c main.go main_test.go | ssh %s files\n", s.hostname())
+	output += fmt.Sprintf("  scp main.go %s:\n", s.hostname())
+	output += fmt.Sprintf("  tail -f app.log | ssh %s tunnel\n", s.hostname())
+	output += fmt.Sprintf("  ssh %s get=abc1234 > fix.patch\n", s.hostname())
+	output += fmt.Sprintf("  ssh %s update=abc1234 < main.go\n", s.hostname())
+	output += fmt.Sprintf("  dmesg | tail | ssh %s append=abc1234\n", s.hostname())
+	output += fmt.Sprintf("  ssh %s list\n\n", s.hostname())
+
+	output += fmt.Sprintf("%s+------------------------+\n", Green)
+
+	return output
+}
+
+// hostname strips the scheme from the configured host for ssh examples.
+func (s *SSHServer) hostname() string {
+	if _, hostname, ok := strings.Cut(s.host, "://"); ok {
+		return hostname
+	}
+	return s.host
+}
+
+func (s *SSHServer) genInvalidOptionsResponse(err error) string {
+	output := fmt.Sprintf("%sInvalid command:%s\n", Red, Reset)
+
+	var optionsErr *InvalidOptionsError
+	if !errors.As(err, &optionsErr) {
+		return output + fmt.Sprintf("%s  - %s%s\n\n", Red, err, Reset)
+	}
+	for _, problem := range optionsErr.Problems {
+		output += fmt.Sprintf("%s  - %s%s\n", Red, problem, Reset)
+	}
+	return output + "\n"
+}
+
+func (s *SSHServer) genSnippetBurnedResponse(key string) string {
+	output := fmt.Sprintf("%sSnippet %s was burned after it was viewed for the first time.%s\n", Red, key, Reset)
+	return output
+}
+
+func (s *SSHServer) genPasswordResponse(key string, err error) string {
+	if errors.Is(err, ErrWrongPassword) {
+		return fmt.Sprintf("%sWrong password for snippet %s.%s\n", Red, key, Reset)
+	}
+	output := fmt.Sprintf("%sSnippet %s is password protected.%s\n", Red, key, Reset)
+	output += fmt.Sprintf("Run: ssh %s get=%s password=<password>\n", s.hostname(), key)
+	return output
+}
+
+func (s *SSHServer) genSnippetEncryptedResponse(key string) string {
+	output := fmt.Sprintf("%sSnippet %s is encrypted and can only be decrypted in the browser with its link.%s\n", Red, key, Reset)
+	return output
+}
+
+func (s *SSHServer) genViewLimitReachedResponse(key string) string {
+	output := fmt.Sprintf("%sSnippet %s has reached its view limit and is no longer available.%s\n", Red, key, Reset)
+	return output
+}
+
+func (s *SSHServer) genUploadTooLargeResponse(err *UploadTooLargeError) string {
+	output := fmt.Sprintf("%sUpload rejected: %s.%s\n", Red, err, Reset)
+	output += fmt.Sprintf("%sNothing was stored.%s\n\n", Red, Reset)
+	return output
+}
+
+func (s *SSHServer) genSnippetNotFoundResponse(key string) string {
+	output := fmt.Sprintf("%sSnippet %s was not found. It may have expired.%s\n", Red, key, Reset)
+	return output
+}
+
 func (s *SSHServer) genRateLimitedResponse() string {
 	output := fmt.Sprintf("%s+------------------------+\n", Gray)
 	output += fmt.Sprintf("|    💻 codesnap.sh 💻   |\n")
@@ -259,14 +889,78 @@ func (s *SSHServer) genRateLimitedResponse() string {
 	return output
 }
 
-func (s *SSHServer) genKey() string {
-	id := uuid.New()
-	h := sha1.New()
-	h.Write([]byte(id.String()))
-	return hex.EncodeToString(h.Sum(nil))[:KeyLength]
+// ownerExtension is the permission extension holding the fingerprint of
+// the key a client authenticated with.
+const ownerExtension = "codesnap-owner"
+
+// AnonymousUser can log in without any auth at all, for clients that have
+// neither a key nor a way to answer keyboard-interactive auth, such as ssh
+// -o BatchMode=yes. Clients always try that "none" auth first, accepting it
+// for every user would make every login anonymous.
+const AnonymousUser = "anonymous"
+
+// authOptions accept any public key, which makes its fingerprint the owner
+// of what the session creates. Clients without a key are let in through
+// keyboard-interactive or password auth without being asked anything, or
+// without auth as AnonymousUser.
+//
+// The public key callback also runs for keys a client merely offers, and
+// all callbacks of a connection share its permissions. The fingerprint it
+// records is only right because the server caches the result for a single
+// key: the callback always ran last for the key whose signature completes
+// the login. The other logins clear it, whatever was offered.
+func authOptions() []ssh.Option {
+	return []ssh.Option{
+		ssh.PublicKeyAuth(func(ctx ssh.Context, key ssh.PublicKey) bool {
+			setOwner(ctx, gossh.FingerprintSHA256(key))
+			return true
+		}),
+		ssh.KeyboardInteractiveAuth(func(ctx ssh.Context, challenger gossh.KeyboardInteractiveChallenge) bool {
+			setOwner(ctx, "")
+			return true
+		}),
+		ssh.PasswordAuth(func(ctx ssh.Context, password string) bool {
+			setOwner(ctx, "")
+			return true
+		}),
+		func(srv *ssh.Server) error {
+			srv.ServerConfigCallback = func(ctx ssh.Context) *gossh.ServerConfig {
+				return &gossh.ServerConfig{
+					NoClientAuth: true,
+					NoClientAuthCallback: func(conn gossh.ConnMetadata) (*gossh.Permissions, error) {
+						if conn.User() != AnonymousUser {
+							return nil, fmt.Errorf("only %s can log in without auth", AnonymousUser)
+						}
+						setOwner(ctx, "")
+						return ctx.Permissions().Permissions, nil
+					},
+				}
+			}
+			return nil
+		},
+	}
+}
+
+func setOwner(ctx ssh.Context, owner string) {
+	perms := ctx.Permissions()
+	if perms.Extensions == nil {
+		perms.Extensions = make(map[string]string)
+	}
+	if owner == "" {
+		delete(perms.Extensions, ownerExtension)
+		return
+	}
+	perms.Extensions[ownerExtension] = owner
 }
 
 func (s *SSHServer) ListenAndServe(addr string, handler ssh.Handler, options ...ssh.Option) error {
 	ssh.Handle(s.HandleSession)
+	options = append(options, authOptions()...)
+	options = append(options,
+		func(srv *ssh.Server) error {
+			srv.SubsystemHandlers = map[string]ssh.SubsystemHandler{"sftp": s.handleSFTPSubsystem}
+			return nil
+		},
+	)
 	return ssh.ListenAndServe(addr, handler, options...)
 }
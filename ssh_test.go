@@ -0,0 +1,153 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"testing"
+
+	"github.com/gliderlabs/ssh"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+func newTestSigner(t *testing.T) gossh.Signer {
+	t.Helper()
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+	signer, err := gossh.NewSignerFromKey(private)
+	if err != nil {
+		t.Fatalf("NewSignerFromKey() error = %v", err)
+	}
+	return signer
+}
+
+// authContext is the part of a connection the auth handlers use.
+type authContext struct {
+	ssh.Context
+	perms *ssh.Permissions
+}
+
+func (c authContext) Permissions() *ssh.Permissions {
+	return c.perms
+}
+
+// permissionsSession is a session with nothing but its permissions.
+type permissionsSession struct {
+	ssh.Session
+	perms *ssh.Permissions
+}
+
+func (s permissionsSession) Permissions() ssh.Permissions {
+	return *s.perms
+}
+
+// sshOwner logs in to a server using authOptions and returns the owner the
+// session was given.
+func sshOwner(t *testing.T, auth ...gossh.AuthMethod) string {
+	t.Helper()
+	owner, err := sshOwnerAs(t, "test", auth...)
+	if err != nil {
+		t.Fatalf("login error = %v", err)
+	}
+	return owner
+}
+
+// sshOwnerAs is sshOwner for the given user, it returns the error of a
+// login that failed.
+func sshOwnerAs(t *testing.T, user string, auth ...gossh.AuthMethod) (string, error) {
+	t.Helper()
+	owners := make(chan string, 1)
+	srv := &ssh.Server{Handler: func(sess ssh.Session) {
+		owners <- sessionOwner(sess)
+	}}
+	for _, option := range authOptions() {
+		if err := srv.SetOption(option); err != nil {
+			t.Fatalf("SetOption() error = %v", err)
+		}
+	}
+	srv.AddHostKey(newTestSigner(t))
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	go srv.Serve(l)
+	t.Cleanup(func() { srv.Close() })
+
+	client, err := gossh.Dial("tcp", l.Addr().String(), &gossh.ClientConfig{
+		User:            user,
+		Auth:            auth,
+		HostKeyCallback: gossh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		return "", err
+	}
+	defer client.Close()
+	sess, err := client.NewSession()
+	if err != nil {
+		t.Fatalf("NewSession() error = %v", err)
+	}
+	defer sess.Close()
+	if err := sess.Run("help"); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	return <-owners, nil
+}
+
+func TestAuthOptions(t *testing.T) {
+	victim := newTestSigner(t)
+	keyboardInteractive := gossh.KeyboardInteractive(func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+		return nil, nil
+	})
+
+	got := sshOwner(t, gossh.PublicKeys(victim))
+	if want := gossh.FingerprintSHA256(victim.PublicKey()); got != want {
+		t.Errorf("owner with a signed key = %q, want %q", got, want)
+	}
+	got = sshOwner(t, keyboardInteractive)
+	if got != "" {
+		t.Errorf("owner without a key = %q, want none", got)
+	}
+	got = sshOwner(t, gossh.Password("anything"))
+	if got != "" {
+		t.Errorf("owner with a password = %q, want none", got)
+	}
+
+	// clients without any auth method only try "none"
+	got, err := sshOwnerAs(t, AnonymousUser)
+	if err != nil {
+		t.Fatalf("login without auth as %s error = %v", AnonymousUser, err)
+	}
+	if got != "" {
+		t.Errorf("owner without auth = %q, want none", got)
+	}
+	if _, err := sshOwnerAs(t, "test"); err == nil {
+		t.Errorf("login without auth as test succeeded, a key would never be asked for")
+	}
+	// with a key the anonymous user still gets in, without an owner
+	got, err = sshOwnerAs(t, AnonymousUser, gossh.PublicKeys(victim))
+	if err != nil || got != "" {
+		t.Errorf("owner as %s with a key = %q, %v, want none", AnonymousUser, got, err)
+	}
+
+	// A client can offer any public key without a signature, the server
+	// only asks for one once the key is accepted. The Go client always
+	// signs, so the handlers are run the way the server runs them.
+	srv := &ssh.Server{}
+	for _, option := range authOptions() {
+		if err := srv.SetOption(option); err != nil {
+			t.Fatalf("SetOption() error = %v", err)
+		}
+	}
+	ctx := authContext{perms: &ssh.Permissions{Permissions: &gossh.Permissions{}}}
+	if !srv.PublicKeyHandler(ctx, victim.PublicKey()) {
+		t.Fatalf("PublicKeyHandler() = false, want any key accepted")
+	}
+	if !srv.KeyboardInteractiveHandler(ctx, nil) {
+		t.Fatalf("KeyboardInteractiveHandler() = false, want true")
+	}
+	if got := sessionOwner(permissionsSession{perms: ctx.perms}); got != "" {
+		t.Errorf("owner with an offered key = %q, want none", got)
+	}
+}
@@ -2,18 +2,72 @@ package main
 
 import (
 	"context"
+	"encoding"
 	"errors"
+	"fmt"
+	"strconv"
 	"time"
 )
 
 var (
 	ErrKeyNotFound = errors.New("key not found")
+	ErrNotInteger  = errors.New("value is not an integer")
+	ErrWrongType   = errors.New("operation against a key holding the wrong kind of value")
 )
 
 type Store interface {
 	Set(ctx context.Context, key string, value any, ttl time.Duration) error
 	Get(ctx context.Context, key string) ([]byte, error)
+	// GetDel atomically returns the value of key and deletes it.
+	GetDel(ctx context.Context, key string) ([]byte, error)
 	Del(ctx context.Context, key string) error
 	Incr(ctx context.Context, key string) (int64, error)
+	Decr(ctx context.Context, key string) (int64, error)
 	Expire(ctx context.Context, key string, ttl time.Duration) error
+	// SAdd adds members to the set stored at key, creating it if needed.
+	SAdd(ctx context.Context, key string, members ...string) error
+	// SRem removes members from the set stored at key.
+	SRem(ctx context.Context, key string, members ...string) error
+	// SMembers returns the members of the set stored at key, or none if
+	// there is no such set.
+	SMembers(ctx context.Context, key string) ([]string, error)
+}
+
+// RewritableStore is a Store whose values can be rewritten in place by
+// background jobs, such as re-encrypting them with a new key.
+type RewritableStore interface {
+	Store
+	// Scan calls fn for every key in the store that holds a value, sets
+	// are left out.
+	Scan(ctx context.Context, fn func(key string) error) error
+	// CompareAndSwap replaces the value of key with newValue if it still is
+	// oldValue, keeping its ttl.
+	CompareAndSwap(ctx context.Context, key string, oldValue, newValue []byte) (bool, error)
+}
+
+// valueToBytes converts the values accepted by Store.Set the same way the
+// redis client does, so that every Store implementation reads them back alike.
+func valueToBytes(value any) ([]byte, error) {
+	switch v := value.(type) {
+	case nil:
+		return []byte{}, nil
+	case string:
+		return []byte(v), nil
+	case []byte:
+		return v, nil
+	case int:
+		return strconv.AppendInt(nil, int64(v), 10), nil
+	case int64:
+		return strconv.AppendInt(nil, v, 10), nil
+	case uint64:
+		return strconv.AppendUint(nil, v, 10), nil
+	case bool:
+		if v {
+			return []byte("1"), nil
+		}
+		return []byte("0"), nil
+	case encoding.BinaryMarshaler:
+		return v.MarshalBinary()
+	}
+	return nil, fmt.Errorf("can't marshal %T (implement encoding.BinaryMarshaler)", value)
 }
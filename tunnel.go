@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"io"
 	"sync"
@@ -9,34 +10,97 @@ import (
 
 const TunnelTTL = 17 * time.Minute // prime number of minutes or it won't work
 
-var ErrStreamSizeExceeded = errors.New("stream size exceeded")
+var (
+	ErrStreamSizeExceeded = errors.New("stream size exceeded")
+	ErrTunnelClosed       = errors.New("tunnel closed")
+)
 
+// TunnelData buffers everything the sender streams so that any number of
+// viewers can follow the tunnel, including the ones that join mid-stream.
+// The replay buffer is bounded by MaxStreamSize.
 type TunnelData struct {
-	reader    io.Reader
-	dataRed   int
+	buf       []byte
+	closed    bool
+	err       error
+	changedCH chan struct{}
 	doneCH    chan struct{}
 	CreatedAt time.Time
-	lock      sync.Mutex
+	// Owner is the SHA256 fingerprint of the ssh key that opened the
+	// tunnel, empty for anonymous senders.
+	Owner string
+	lock  sync.RWMutex
 }
 
-func NewTunnelData(reader io.Reader) *TunnelData {
+func NewTunnelData() *TunnelData {
 	return &TunnelData{
+		changedCH: make(chan struct{}),
 		doneCH:    make(chan struct{}),
 		CreatedAt: time.Now(),
-		reader:    reader,
 	}
 }
 
-func (td *TunnelData) Read(p []byte) (int, error) {
+// Write appends p to the replay buffer and wakes up all waiting viewers.
+func (td *TunnelData) Write(p []byte) (int, error) {
 	td.lock.Lock()
 	defer td.lock.Unlock()
 
-	n, err := td.reader.Read(p)
-	td.dataRed += n
-	if td.dataRed > MaxStreamSize {
+	if td.closed {
+		return 0, ErrTunnelClosed
+	}
+	if len(td.buf)+len(p) > MaxStreamSize {
+		td.closeLocked(ErrStreamSizeExceeded)
 		return 0, ErrStreamSizeExceeded
 	}
-	return n, err
+	td.buf = append(td.buf, p...)
+	td.notifyLocked()
+	return len(p), nil
+}
+
+// Close marks the end of the stream. Viewers drain the replay buffer and
+// then receive err, or io.EOF if err is nil.
+func (td *TunnelData) Close(err error) {
+	td.lock.Lock()
+	defer td.lock.Unlock()
+	td.closeLocked(err)
+}
+
+func (td *TunnelData) closeLocked(err error) {
+	if td.closed {
+		return
+	}
+	td.closed = true
+	td.err = err
+	close(td.doneCH)
+	td.notifyLocked()
+}
+
+func (td *TunnelData) notifyLocked() {
+	close(td.changedCH)
+	td.changedCH = make(chan struct{})
+}
+
+// NewReader returns a reader that replays the stream from offset and then
+// follows it live until the tunnel is closed or ctx is cancelled.
+func (td *TunnelData) NewReader(ctx context.Context, offset int) *TunnelReader {
+	return &TunnelReader{
+		tunnel: td,
+		ctx:    ctx,
+		offset: offset,
+	}
+}
+
+// Size returns the number of bytes streamed so far.
+func (td *TunnelData) Size() int {
+	td.lock.RLock()
+	defer td.lock.RUnlock()
+	return len(td.buf)
+}
+
+// Err returns the error the stream was closed with, if any.
+func (td *TunnelData) Err() error {
+	td.lock.RLock()
+	defer td.lock.RUnlock()
+	return td.err
 }
 
 func (td *TunnelData) Wait() {
@@ -44,9 +108,41 @@ func (td *TunnelData) Wait() {
 }
 
 func (td *TunnelData) Done() {
-	td.lock.Lock()
-	defer td.lock.Unlock()
-	close(td.doneCH)
+	td.Close(nil)
+}
+
+type TunnelReader struct {
+	tunnel *TunnelData
+	ctx    context.Context
+	offset int
+}
+
+func (tr *TunnelReader) Read(p []byte) (int, error) {
+	for {
+		tr.tunnel.lock.RLock()
+		if tr.offset < len(tr.tunnel.buf) {
+			n := copy(p, tr.tunnel.buf[tr.offset:])
+			tr.offset += n
+			tr.tunnel.lock.RUnlock()
+			return n, nil
+		}
+		if tr.tunnel.closed {
+			err := tr.tunnel.err
+			tr.tunnel.lock.RUnlock()
+			if err == nil {
+				err = io.EOF
+			}
+			return 0, err
+		}
+		changedCH := tr.tunnel.changedCH
+		tr.tunnel.lock.RUnlock()
+
+		select {
+		case <-changedCH:
+		case <-tr.ctx.Done():
+			return 0, tr.ctx.Err()
+		}
+	}
 }
 
 type TunnelManager struct {
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+)
+
+func TestTunnelData_MultipleViewers(t *testing.T) {
+	tunnel := NewTunnelData()
+
+	const viewers = 3
+	results := make([]string, viewers)
+	var wg sync.WaitGroup
+	for i := 0; i < viewers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			data, err := io.ReadAll(tunnel.NewReader(context.Background(), 0))
+			if err != nil {
+				t.Errorf("viewer %d: ReadAll() error = %v", i, err)
+			}
+			results[i] = string(data)
+		}(i)
+	}
+
+	for _, chunk := range []string{"hello ", "from ", "the tunnel"} {
+		if _, err := tunnel.Write([]byte(chunk)); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+	}
+	tunnel.Close(nil)
+	wg.Wait()
+
+	for i, got := range results {
+		if got != "hello from the tunnel" {
+			t.Errorf("viewer %d got %q", i, got)
+		}
+	}
+}
+
+func TestTunnelData_LateViewerReplays(t *testing.T) {
+	tunnel := NewTunnelData()
+	if _, err := tunnel.Write([]byte("first line\n")); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+
+	reader := tunnel.NewReader(context.Background(), 0)
+	if _, err := tunnel.Write([]byte("second line\n")); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	tunnel.Done()
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("ReadAll() error = %v", err)
+	}
+	if string(data) != "first line\nsecond line\n" {
+		t.Errorf("got %q", data)
+	}
+}
+
+func TestTunnelData_StreamSizeExceeded(t *testing.T) {
+	tunnel := NewTunnelData()
+	if _, err := tunnel.Write(make([]byte, MaxStreamSize+1)); !errors.Is(err, ErrStreamSizeExceeded) {
+		t.Fatalf("Write() error = %v, want %v", err, ErrStreamSizeExceeded)
+	}
+	if _, err := tunnel.Write([]byte("x")); !errors.Is(err, ErrTunnelClosed) {
+		t.Errorf("Write() after close error = %v, want %v", err, ErrTunnelClosed)
+	}
+	if _, err := io.ReadAll(tunnel.NewReader(context.Background(), 0)); !errors.Is(err, ErrStreamSizeExceeded) {
+		t.Errorf("ReadAll() error = %v, want %v", err, ErrStreamSizeExceeded)
+	}
+}
+
+func TestTunnelReader_ContextCancelled(t *testing.T) {
+	tunnel := NewTunnelData()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := tunnel.NewReader(ctx, 0).Read(make([]byte, 8))
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Read() error = %v, want %v", err, context.Canceled)
+	}
+}